@@ -15,10 +15,9 @@
 package channel
 
 import (
-	"fmt"
-	"log"
+	"sync/atomic"
 
-	eth "github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
 	pchannel "perun.network/go-perun/channel"
 	pwallet "perun.network/go-perun/wallet"
 )
@@ -26,11 +25,47 @@ import (
 // backend implements the backend interface.
 // The type is private since it only needs to be exposed as singleton by the
 // `Backend` variable.
-type backend struct{}
+type backend struct {
+	hasher      uint32 // Hasher, accessed atomically
+	timeoutMode uint32 // TimeoutMode, accessed atomically
+}
 
 // Backend is the channel backend. Is a singleton since there is only one backend.
 var Backend backend
 
+// SetHasher configures the hasher that is used to calculate channel and
+// funding IDs. Must match the hasher of the pallet.
+// Returns ErrUnknownHasher if the hasher is not supported.
+func (b *backend) SetHasher(h Hasher) error {
+	if err := h.Valid(); err != nil {
+		return err
+	}
+	atomic.StoreUint32(&b.hasher, uint32(h))
+	return nil
+}
+
+// Hasher returns the configured hasher. Defaults to DefaultHasher.
+func (b *backend) Hasher() Hasher {
+	return Hasher(atomic.LoadUint32(&b.hasher))
+}
+
+// SetTimeoutMode configures how challenge durations and timeouts are
+// interpreted. Must match the timeout mode of the pallet.
+// Returns ErrUnknownTimeoutMode if the mode is not supported.
+func (b *backend) SetTimeoutMode(m TimeoutMode) error {
+	if err := m.Valid(); err != nil {
+		return err
+	}
+	atomic.StoreUint32(&b.timeoutMode, uint32(m))
+	return nil
+}
+
+// TimeoutMode returns the configured timeout mode. Defaults to
+// DefaultTimeoutMode.
+func (b *backend) TimeoutMode() TimeoutMode {
+	return TimeoutMode(atomic.LoadUint32(&b.timeoutMode))
+}
+
 // CalcID calculates the channelID.
 func (*backend) CalcID(params *pchannel.Params) (id pchannel.ID) {
 	return CalcID(params)
@@ -68,15 +103,27 @@ func (*backend) NewAsset() pchannel.Asset {
 	return Asset
 }
 
-// CalcID calculates the channelID by encoding and hashing the params.
+// CalcID calculates the channelID by encoding and hashing the params with the
+// configured hasher. Panics if the params are invalid, use TryCalcID for
+// params from untrusted sources.
 func CalcID(params *pchannel.Params) (id pchannel.ID) {
+	id, err := TryCalcID(params)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// TryCalcID calculates the channelID like CalcID but returns an error
+// instead of panicking if the params are invalid.
+func TryCalcID(params *pchannel.Params) (pchannel.ID, error) {
 	_params, err := NewParams(params)
 	if err != nil {
-		panic(fmt.Sprintf("cannot calculate channel ID: %v", err))
+		return pchannel.ID{}, errors.WithMessage(err, "cannot calculate channel ID")
 	}
 	bytes, err := ScaleEncode(_params)
 	if err != nil {
-		log.Panicf("could not encode parameters: %v", err)
+		return pchannel.ID{}, errors.WithMessage(err, "could not encode parameters")
 	}
-	return eth.Keccak256Hash(bytes)
+	return Backend.Hasher().Hash(bytes), nil
 }
@@ -18,7 +18,6 @@ import (
 	"math/big"
 
 	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/pkg/errors"
 	pchannel "perun.network/go-perun/channel"
 )
@@ -58,6 +57,8 @@ type (
 	// Version of a state as defined by go-perun.
 	Version = uint64
 	// ChallengeDuration the duration of a challenge as defined by go-perun.
+	// It is measured in seconds or blocks, depending on the TimeoutMode of
+	// the Backend.
 	ChallengeDuration = uint64
 	// Balance is the balance of an on- or off-chain Address.
 	Balance = types.U128
@@ -116,7 +117,9 @@ type (
 		Phase DisputePhase
 		// State is the state of the channel.
 		State State
-		// Timeout is the duration that the dispute can be refuted in.
+		// Timeout is the point at which the dispute can no longer be
+		// refuted. It is a unix timestamp in seconds or a block number,
+		// depending on the TimeoutMode of the Backend.
 		Timeout ChallengeDuration
 	}
 )
@@ -135,6 +138,10 @@ var (
 	ErrStateIncompatible = errors.New("incompatible state")
 	// ErrIdentLenMismatch the length of an identity was wrong.
 	ErrIdentLenMismatch = errors.New("length of an identity was wrong")
+	// ErrSigLen the length of a signature was wrong.
+	ErrSigLen = errors.New("length of a signature was wrong")
+	// ErrInvalidPoint an identity was not a valid sr25519 public key.
+	ErrInvalidPoint = errors.New("identity is not a valid sr25519 point")
 )
 
 // NewFunding returns a new Funding.
@@ -142,12 +149,13 @@ func NewFunding(id ChannelID, part OffIdentity) *Funding {
 	return &Funding{id, part}
 }
 
-// ID calculates the funding ID by encoding and hashing the Funding.
+// ID calculates the funding ID by encoding and hashing the Funding with the
+// configured hasher.
 func (p Funding) ID() (FundingID, error) {
 	var fid FundingID
 	data, err := ScaleEncode(&p)
 	if err != nil {
 		return fid, errors.WithMessage(err, "calculating funding ID")
 	}
-	return FundingID(crypto.Keccak256Hash(data)), nil
+	return Backend.Hasher().Hash(data), nil
 }
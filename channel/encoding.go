@@ -15,11 +15,13 @@
 package channel
 
 import (
-	"log"
+	"math"
 	"math/big"
 	"time"
 
+	"github.com/centrifuge/go-substrate-rpc-client/v3/scale"
 	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/perun-network/perun-polkadot-backend/pkg/sr25519"
 	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
 	"github.com/pkg/errors"
 	pchannel "perun.network/go-perun/channel"
@@ -32,20 +34,172 @@ func ScaleEncode(obj interface{}) ([]byte, error) {
 }
 
 // ScaleDecode decodes any struct according to the SCALE codec.
+// Params and State are decoded such that the memory usage is bounded by the
+// length of data, which makes it safe to decode them from untrusted sources.
 func ScaleDecode(obj interface{}, data []byte) error {
 	return types.DecodeFromBytes(data, obj)
 }
 
+// readChunkSize is the maximal number of bytes that are allocated at once
+// when decoding a byte vector.
+const readChunkSize = 1024
+
+// Decode decodes the Params from the decoder. Implements scale.Decodeable.
+func (p *Params) Decode(d scale.Decoder) error {
+	if err := d.Decode(&p.Nonce); err != nil {
+		return err
+	}
+	n, err := decodeVecLen(d)
+	if err != nil {
+		return err
+	}
+	p.Participants = nil
+	for i := 0; i < n; i++ {
+		var part OffIdentity
+		if err := d.Decode(&part); err != nil {
+			return err
+		}
+		p.Participants = append(p.Participants, part)
+	}
+	if err := d.Decode(&p.ChallengeDuration); err != nil {
+		return err
+	}
+	return d.Decode(&p.App)
+}
+
+// Decode decodes the State from the decoder. Implements scale.Decodeable.
+func (s *State) Decode(d scale.Decoder) error {
+	if err := d.Decode(&s.Channel); err != nil {
+		return err
+	}
+	if err := d.Decode(&s.Version); err != nil {
+		return err
+	}
+	n, err := decodeVecLen(d)
+	if err != nil {
+		return err
+	}
+	s.Balances = nil
+	for i := 0; i < n; i++ {
+		var bal Balance
+		if err := d.Decode(&bal); err != nil {
+			return err
+		}
+		s.Balances = append(s.Balances, bal)
+	}
+	if err := d.Decode(&s.Final); err != nil {
+		return err
+	}
+	s.Data, err = decodeBytes(d)
+	return err
+}
+
+// Encode encodes the RegisteredState. The Timeout is encoded as u32 block
+// number if the Backend uses BlockTimeout and as u64 timestamp otherwise.
+// Implements scale.Encodeable.
+func (r RegisteredState) Encode(e scale.Encoder) error {
+	if err := e.Encode(r.Phase); err != nil {
+		return err
+	}
+	if err := e.Encode(r.State); err != nil {
+		return err
+	}
+	if Backend.TimeoutMode() == BlockTimeout {
+		if r.Timeout > math.MaxUint32 {
+			return errors.New("block number out of range")
+		}
+		return e.Encode(uint32(r.Timeout))
+	}
+	return e.Encode(r.Timeout)
+}
+
+// Decode decodes the RegisteredState. See Encode for the Timeout.
+// Implements scale.Decodeable.
+func (r *RegisteredState) Decode(d scale.Decoder) error {
+	if err := d.Decode(&r.Phase); err != nil {
+		return err
+	}
+	if err := d.Decode(&r.State); err != nil {
+		return err
+	}
+	if Backend.TimeoutMode() == BlockTimeout {
+		var block uint32
+		err := d.Decode(&block)
+		r.Timeout = ChallengeDuration(block)
+		return err
+	}
+	return d.Decode(&r.Timeout)
+}
+
+// Decode decodes the Topics from the decoder. Implements scale.Decodeable.
+func (t *Topics) Decode(d scale.Decoder) error {
+	n, err := decodeVecLen(d)
+	if err != nil {
+		return err
+	}
+	*t = nil
+	for i := 0; i < n; i++ {
+		var topic types.Hash
+		if err := d.Decode(&topic); err != nil {
+			return err
+		}
+		*t = append(*t, topic)
+	}
+	return nil
+}
+
+// decodeVecLen decodes the compact length prefix of a vector.
+// The length is not checked against the remaining input, callers must
+// only allocate memory for elements that they successfully decoded.
+func decodeVecLen(d scale.Decoder) (int, error) {
+	n, err := d.DecodeUintCompact()
+	if err != nil {
+		return 0, err
+	}
+	if !n.IsUint64() || n.Uint64() > math.MaxUint32 {
+		return 0, errors.New("vector length out of range")
+	}
+	return int(n.Uint64()), nil
+}
+
+// decodeBytes decodes a byte vector in chunks of readChunkSize.
+// Returns nil for an empty vector.
+func decodeBytes(d scale.Decoder) ([]byte, error) {
+	n, err := decodeVecLen(d)
+	if err != nil {
+		return nil, err
+	}
+	var ret []byte
+	for len(ret) < n {
+		chunk := make([]byte, min(n-len(ret), readChunkSize))
+		if err := d.Read(chunk); err != nil {
+			return nil, err
+		}
+		ret = append(ret, chunk...)
+	}
+	return ret, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 // MakeBalance creates a new Balance.
 func MakeBalance(bal *big.Int) (Balance, error) {
-	if bal.Sign() < 0 || bal.Cmp(MaxBalance.Int) > 0 {
+	if bal == nil || bal.Sign() < 0 || bal.Cmp(MaxBalance.Int) > 0 {
 		return types.NewU128(*big.NewInt(0)), errors.New("invalid balance")
 	}
 	return types.NewU128(*bal), nil
 }
 
-// MakePerunBalance creates a Perun balance.
+// MakePerunBalance creates a Perun balance. A zero Balance results in 0.
 func MakePerunBalance(bal Balance) *big.Int {
+	if bal.Int == nil {
+		return new(big.Int)
+	}
 	return new(big.Int).Set(bal.Int)
 }
 
@@ -64,7 +218,7 @@ func MakePerunBalances(bals []Balance) pchannel.Balances {
 	ret := make(pchannel.Balances, 1)
 	ret[0] = make([]pchannel.Bal, len(bals))
 	for i, bal := range bals {
-		ret[0][i] = new(big.Int).Set(bal.Int)
+		ret[0][i] = MakePerunBalance(bal)
 	}
 	return ret
 }
@@ -89,20 +243,34 @@ func MakeTime(sec ChallengeDuration) time.Time {
 	return time.Unix(int64(sec), 0)
 }
 
-// MakeTimeout creates a new timeout.
-func MakeTimeout(sec ChallengeDuration, storage substrate.StorageQueryer) pchannel.Timeout {
-	return substrate.NewTimeout(storage, MakeTime(sec), substrate.DefaultTimeoutPollInterval)
+// MakeTimeout creates a new timeout that expires at the on-chain timeout of
+// a dispute. The timeout is a unix timestamp or a block number, depending on
+// the TimeoutMode of the Backend.
+func MakeTimeout(timeout ChallengeDuration, storage substrate.StorageQueryer) pchannel.Timeout {
+	if Backend.TimeoutMode() == BlockTimeout {
+		return substrate.NewBlockTimeout(storage, MakeBlockNumber(timeout), substrate.DefaultTimeoutPollInterval)
+	}
+	return substrate.NewTimeout(storage, MakeTime(timeout), substrate.DefaultTimeoutPollInterval)
+}
+
+// MakeBlockNumber creates a new block number from the argument.
+// Saturates at the highest block number.
+func MakeBlockNumber(n ChallengeDuration) types.BlockNumber {
+	if n > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return types.BlockNumber(n)
 }
 
 // MakeNonce creates a new Nonce or an error if the argument was out of range.
 func MakeNonce(nonce *big.Int) (Nonce, error) {
 	var ret Nonce
 
-	if nonce.Sign() < 0 { // negative?
-		return ret, ErrNonceOutOfRange
+	if nonce == nil {
+		return ret, errors.WithMessage(ErrNonceOutOfRange, "nonce is nil")
 	}
-	if nonce.BitLen() > (8*NonceLen) || nonce.BitLen() == 0 { // too long/short?
-		return ret, ErrNonceOutOfRange
+	if nonce.Sign() <= 0 || nonce.BitLen() > (8*NonceLen) {
+		return ret, errors.WithMessagef(ErrNonceOutOfRange, "nonce %v", nonce)
 	}
 	copy(ret[:], nonce.Bytes())
 
@@ -112,6 +280,9 @@ func MakeNonce(nonce *big.Int) (Nonce, error) {
 // NewState creates a new State. It discards app data because app-channels are
 // currently not supported.
 func NewState(s *pchannel.State) (*State, error) {
+	if s == nil || s.Data == nil {
+		return nil, ErrStateIncompatible
+	}
 	if err := s.Valid(); err != nil {
 		return nil, ErrStateIncompatible
 	}
@@ -134,12 +305,15 @@ func NewState(s *pchannel.State) (*State, error) {
 	}, err
 }
 
-// NewPerunState creates a new Perun state and fills the App-Data with a
-// default value.
-func NewPerunState(s *State, app pchannel.App) *pchannel.State {
+// NewPerunState creates a new Perun state and decodes the App-Data with the
+// passed app. Returns an error if the app data could not be decoded.
+func NewPerunState(s *State, app pchannel.App) (*pchannel.State, error) {
+	if app == nil {
+		return nil, errors.New("app is nil")
+	}
 	data := app.NewData()
 	if err := data.UnmarshalBinary(s.Data); err != nil {
-		log.Panicf("decoding app data: %v", err)
+		return nil, errors.WithMessage(err, "decoding app data")
 	}
 
 	return &pchannel.State{
@@ -149,18 +323,18 @@ func NewPerunState(s *State, app pchannel.App) *pchannel.State {
 		Allocation: MakePerunAlloc(s.Balances),
 		Data:       data,
 		IsFinal:    s.Final,
-	}
+	}, nil
 }
 
 // MakeAlloc converts an Allocation to Balances. Currently, it supports only a
 // single asset.
 func MakeAlloc(a *pchannel.Allocation) ([]Balance, error) {
 	var err error
-	ret := make([]Balance, len(a.Balances[0]))
-
 	if len(a.Assets) != 1 || len(a.Balances) != 1 || len(a.Locked) != 0 {
-		return ret, ErrAllocIncompatible
+		return nil, ErrAllocIncompatible
 	}
+	ret := make([]Balance, len(a.Balances[0]))
+
 	for i, bal := range a.Balances[0] {
 		if ret[i], err = MakeBalance(bal); err != nil {
 			break
@@ -185,6 +359,9 @@ func NewWithdrawal(cid pchannel.ID, part, receiver pwallet.Address) (*Withdrawal
 
 // NewParams creates backend-specific parameters from generic Perun parameters.
 func NewParams(p *pchannel.Params) (*Params, error) {
+	if p == nil || p.App == nil {
+		return nil, errors.New("incompatible params")
+	}
 	nonce, err := MakeNonce(p.Nonce)
 	if err != nil {
 		return nil, err
@@ -211,11 +388,12 @@ func NewParams(p *pchannel.Params) (*Params, error) {
 }
 
 // MakeSig creates a new Sig.
+// Returns ErrSigLen if the signature does not have exactly SigLen bytes.
 func MakeSig(sig pwallet.Sig) (Sig, error) {
 	var ret Sig
 
 	if len(sig) != SigLen {
-		return ret, nil
+		return ret, errors.WithMessagef(ErrSigLen, "got %d bytes, want %d", len(sig), SigLen)
 	}
 	copy(ret[:], sig)
 
@@ -229,7 +407,7 @@ func MakeSigs(sigs []pwallet.Sig) ([]Sig, error) {
 
 	for i, sig := range sigs {
 		if ret[i], err = MakeSig(sig); err != nil {
-			break
+			return nil, errors.WithMessagef(err, "sig %d", i)
 		}
 	}
 
@@ -238,6 +416,9 @@ func MakeSigs(sigs []pwallet.Sig) ([]Sig, error) {
 
 // MakeFundingReq creates a new Funding.
 func MakeFundingReq(req *pchannel.FundingReq) (Funding, error) {
+	if int(req.Idx) >= len(req.Params.Parts) {
+		return Funding{}, errors.New("participant index out of range")
+	}
 	ident, err := MakeOffIdent(req.Params.Parts[req.Idx])
 
 	return Funding{
@@ -247,37 +428,52 @@ func MakeFundingReq(req *pchannel.FundingReq) (Funding, error) {
 }
 
 // MakeOnIdent creates a new OnIdentity.
+// Returns ErrIdentLenMismatch if the address has the wrong length. The
+// address is not required to be an sr25519 key since on-chain accounts can
+// be derived otherwise, e.g. for multi-sig accounts.
 func MakeOnIdent(addr pwallet.Address) (OnIdentity, error) {
 	var ret OnIdentity
-	data, err := addr.MarshalBinary()
+	data, err := marshalIdent(addr, OnIdentityLen)
 	if err != nil {
 		return ret, err
 	}
-
-	if len(data) != OnIdentityLen {
-		return ret, ErrIdentLenMismatch
-	}
 	copy(ret[:], data)
 
 	return ret, nil
 }
 
 // MakeOffIdent creates a new OffIdentity.
+// Returns ErrIdentLenMismatch if the address has the wrong length and
+// ErrInvalidPoint if it is not a valid sr25519 public key.
 func MakeOffIdent(part pwallet.Address) (OffIdentity, error) {
 	var ret OffIdentity
-	data, err := part.MarshalBinary()
+	data, err := marshalIdent(part, OffIdentityLen)
 	if err != nil {
 		return ret, err
 	}
-
-	if len(data) != OffIdentityLen {
-		return ret, ErrIdentLenMismatch
+	if _, err := sr25519.NewPK(data); err != nil {
+		return ret, errors.WithMessage(ErrInvalidPoint, err.Error())
 	}
 	copy(ret[:], data)
 
 	return ret, nil
 }
 
+// marshalIdent marshals the address and checks that it has length l.
+func marshalIdent(addr pwallet.Address, l int) ([]byte, error) {
+	if addr == nil {
+		return nil, errors.WithMessage(ErrIdentLenMismatch, "address is nil")
+	}
+	data, err := addr.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != l {
+		return nil, errors.WithMessagef(ErrIdentLenMismatch, "got %d bytes, want %d", len(data), l)
+	}
+	return data, nil
+}
+
 // MakeOffIdents creates a new []OffIdentity.
 func MakeOffIdents(parts []pwallet.Address) ([]OffIdentity, error) {
 	var err error
@@ -285,7 +481,7 @@ func MakeOffIdents(parts []pwallet.Address) ([]OffIdentity, error) {
 
 	for i, part := range parts {
 		if ret[i], err = MakeOffIdent(part); err != nil {
-			break
+			return nil, errors.WithMessagef(err, "participant %d", i)
 		}
 	}
 
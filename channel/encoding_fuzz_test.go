@@ -0,0 +1,212 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package channel_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/scale"
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	pchannel "perun.network/go-perun/channel"
+	pwallettest "perun.network/go-perun/wallet/test"
+	pkgtest "polycry.pt/poly-go/test"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+)
+
+// The fuzz tests decode untrusted chain data and convert it into go-perun
+// types in the same way as the pallet package does. They pass as long as
+// nothing panics or exhausts the memory.
+// Run them with `go test -fuzz FuzzScaleDecodeState ./channel`.
+
+func FuzzScaleDecodeState(f *testing.F) {
+	addGoldenStates(f, nil)
+	app := pchannel.NewMockApp(pwallettest.NewRandomAddress(pkgtest.Prng(f)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var state channel.State
+		if err := channel.ScaleDecode(&state, data); err != nil {
+			return
+		}
+		convertState(&state, app)
+	})
+}
+
+func FuzzScaleDecodeRegisteredState(f *testing.F) {
+	addGoldenStates(f, []byte{byte(channel.ProgressPhase)})
+	app := pchannel.NewMockApp(pwallettest.NewRandomAddress(pkgtest.Prng(f)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var reg channel.RegisteredState
+		if err := channel.ScaleDecode(&reg, data); err != nil {
+			return
+		}
+		convertState(&reg.State, app)
+	})
+}
+
+// FuzzDecodeEvents decodes the fields of Perun events like
+// types.EventRecordsRaw.DecodeEventRecords does. The first byte selects the
+// event. Phase and Topics are omitted since they are framing of the node and
+// not controlled by channel participants.
+func FuzzDecodeEvents(f *testing.F) {
+	for i := byte(0); i < 5; i++ {
+		addGoldenStates(f, append([]byte{i}, make([]byte, 32)...))
+	}
+	app := pchannel.NewMockApp(pwallettest.NewRandomAddress(pkgtest.Prng(f)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) == 0 {
+			return
+		}
+		d := scale.NewDecoder(bytes.NewReader(data[1:]))
+		switch data[0] % 5 {
+		case 0:
+			var e channel.DepositedEvent
+			_ = decodeAll(d, &e.Fid, &e.Balance)
+			channel.MakePerunBalance(e.Balance)
+		case 1:
+			var e channel.DisputedEvent
+			if decodeAll(d, &e.Cid, &e.State) == nil {
+				convertState(&e.State, app)
+			}
+		case 2:
+			var e channel.ProgressedEvent
+			_ = decodeAll(d, &e.Cid, &e.Version, &e.App)
+		case 3:
+			var e channel.ConcludedEvent
+			_ = decodeAll(d, &e.Cid)
+		case 4:
+			var e channel.WithdrawnEvent
+			_ = decodeAll(d, &e.Fid)
+		}
+	})
+}
+
+// FuzzDecodeEventRecords decodes raw event records with the metadata of a
+// chain that has the Perun pallet deployed, in the same way as the event
+// subscriptions of the pallet package do.
+func FuzzDecodeEventRecords(f *testing.F) {
+	meta := perunEventMetadata()
+	for _, h := range goldenHashers {
+		golden := loadGolden(f, h)
+		for _, vec := range golden.Vectors {
+			f.Add(encodeEventRecord(f, 1, vec.State.Encoded))
+		}
+	}
+	for i := byte(0); i < 5; i++ {
+		f.Add(encodeEventRecord(f, i, make([]byte, 128)))
+	}
+	app := pchannel.NewMockApp(pwallettest.NewRandomAddress(pkgtest.Prng(f)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		records, err := channel.DecodeEventRecords(data, meta)
+		if err != nil {
+			return
+		}
+		for _, e := range records.Events() {
+			switch e := e.(type) {
+			case *channel.DepositedEvent:
+				channel.MakePerunBalance(e.Balance)
+			case *channel.DisputedEvent:
+				convertState(&e.State, app)
+			}
+		}
+	})
+}
+
+// perunEventMetadata returns metadata that only contains the events of the
+// Perun pallet in the order of channel.EventRecords.
+func perunEventMetadata() *types.Metadata {
+	meta := types.NewMetadataV13()
+	meta.AsMetadataV13.Modules = []types.ModuleMetadataV13{{
+		Name:      "PerunModule",
+		Index:     perunModuleIndex,
+		HasEvents: true,
+		Events: []types.EventMetadataV4{
+			{Name: "Deposited"},
+			{Name: "Disputed"},
+			{Name: "Progressed"},
+			{Name: "Concluded"},
+			{Name: "Withdrawn"},
+		},
+	}}
+	return meta
+}
+
+const perunModuleIndex = 8
+
+// encodeEventRecord encodes a single event record of the Perun pallet with
+// the given event index and fields.
+func encodeEventRecord(f *testing.F, event byte, fields []byte) []byte {
+	var buf bytes.Buffer
+	enc := scale.NewEncoder(&buf)
+	err := encodeAll(enc,
+		types.NewUCompactFromUInt(1),
+		types.Phase{IsApplyExtrinsic: true},
+		types.EventID{perunModuleIndex, event},
+	)
+	if err != nil {
+		f.Fatal(err)
+	}
+	buf.Write(fields)
+	if err := enc.Encode([]types.Hash{}); err != nil {
+		f.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// convertState converts a decoded State into a Perun state with and without
+// an app.
+func convertState(state *channel.State, app pchannel.App) {
+	if s, err := channel.NewPerunState(state, app); err == nil {
+		_, _ = channel.NewState(s)
+	}
+	if s, err := channel.NewPerunState(state, pchannel.NoApp()); err == nil {
+		_, _ = channel.NewState(s)
+	}
+}
+
+func decodeAll(d *scale.Decoder, objs ...interface{}) error {
+	for _, obj := range objs {
+		if err := d.Decode(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeAll(e *scale.Encoder, objs ...interface{}) error {
+	for _, obj := range objs {
+		if err := e.Encode(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addGoldenStates adds the encoded golden states with a prefix to the corpus.
+func addGoldenStates(f *testing.F, prefix []byte) {
+	for _, h := range goldenHashers {
+		golden := loadGolden(f, h)
+		for _, vec := range golden.Vectors {
+			f.Add(append(append([]byte{}, prefix...), vec.State.Encoded...))
+		}
+	}
+}
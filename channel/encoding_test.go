@@ -0,0 +1,106 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channel_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pchannel "perun.network/go-perun/channel"
+	pwallettest "perun.network/go-perun/wallet/test"
+	pkgtest "polycry.pt/poly-go/test"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+)
+
+// maxVecLen is a compact encoded vector length of 2^32-1.
+var maxVecLen = []byte{0x03, 0xff, 0xff, 0xff, 0xff}
+
+// TestScaleDecode_LengthPrefix checks that huge length prefixes without
+// matching data return an error instead of allocating the memory.
+func TestScaleDecode_LengthPrefix(t *testing.T) {
+	var prefix []byte
+	prefix = append(prefix, make([]byte, 32)...) // Channel
+	prefix = append(prefix, make([]byte, 8)...)  // Version
+
+	t.Run("Balances", func(t *testing.T) {
+		data := append(append([]byte{}, prefix...), maxVecLen...)
+		assert.Error(t, channel.ScaleDecode(new(channel.State), data))
+	})
+	t.Run("Data", func(t *testing.T) {
+		data := append(append([]byte{}, prefix...), 0x00, 0x00) // no balances, not final
+		data = append(data, maxVecLen...)
+		data = append(data, bytes.Repeat([]byte{1}, 3000)...)
+		assert.Error(t, channel.ScaleDecode(new(channel.State), data))
+	})
+	t.Run("Participants", func(t *testing.T) {
+		data := append(make([]byte, channel.NonceLen), maxVecLen...)
+		assert.Error(t, channel.ScaleDecode(new(channel.Params), data))
+	})
+	t.Run("RegisteredState", func(t *testing.T) {
+		data := append([]byte{byte(channel.RegisterPhase)}, prefix...)
+		data = append(data, maxVecLen...)
+		assert.Error(t, channel.ScaleDecode(new(channel.RegisteredState), data))
+	})
+}
+
+func TestNewPerunState_InvalidData(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	app := pchannel.NewMockApp(pwallettest.NewRandomAddress(rng))
+	state := &channel.State{Balances: []channel.Balance{channel.MaxBalance}, Data: []byte{1, 2, 3}}
+
+	_, err := channel.NewPerunState(state, app)
+	assert.Error(t, err)
+	_, err = channel.NewPerunState(state, nil)
+	assert.Error(t, err)
+
+	state.Data = make([]byte, 8) // a valid MockOp
+	pState, err := channel.NewPerunState(state, app)
+	require.NoError(t, err)
+	assert.Equal(t, channel.MaxBalance.Int, pState.Balances[0][0])
+}
+
+func TestTryCalcID(t *testing.T) {
+	s := newSetup(pkgtest.Prng(t))
+	id, err := channel.TryCalcID(s.Params)
+	require.NoError(t, err)
+	assert.Equal(t, channel.CalcID(s.Params), id)
+
+	invalid := *s.Params
+	invalid.Nonce = nil
+	_, err = channel.TryCalcID(&invalid)
+	assert.Error(t, err)
+	assert.Panics(t, func() { channel.CalcID(&invalid) })
+	_, err = channel.TryCalcID(nil)
+	assert.Error(t, err)
+}
+
+func TestConversion_Invalid(t *testing.T) {
+	_, err := channel.MakeBalance(nil)
+	assert.Error(t, err)
+	assert.Zero(t, channel.MakePerunBalance(channel.Balance{}).Sign())
+	_, err = channel.MakeNonce(nil)
+	assert.ErrorIs(t, err, channel.ErrNonceOutOfRange)
+	_, err = channel.MakeOffIdent(nil)
+	assert.ErrorIs(t, err, channel.ErrIdentLenMismatch)
+	_, err = channel.MakeAlloc(&pchannel.Allocation{})
+	assert.ErrorIs(t, err, channel.ErrAllocIncompatible)
+	_, err = channel.NewState(nil)
+	assert.ErrorIs(t, err, channel.ErrStateIncompatible)
+	_, err = channel.MakeFundingReq(&pchannel.FundingReq{Params: &pchannel.Params{}, Idx: 1})
+	assert.Error(t, err)
+}
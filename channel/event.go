@@ -15,7 +15,11 @@
 package channel
 
 import (
+	"math"
+	"sort"
+
 	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
 )
 
 type (
@@ -31,6 +35,10 @@ type (
 		PerunModule_Withdrawn  []WithdrawnEvent  // nolint: stylecheck
 	}
 
+	// Topics are the indexed topics of an event. They are decoded such that
+	// the memory usage is bounded by the length of the input.
+	Topics []types.Hash
+
 	// PerunEvent is a Perun event.
 	PerunEvent interface{}
 
@@ -38,8 +46,8 @@ type (
 	DepositedEvent struct {
 		Phase   types.Phase // required
 		Fid     FundingID
-		Balance Balance      // total deposit of the Fid
-		Topics  []types.Hash // required
+		Balance Balance // total deposit of the Fid
+		Topics  Topics  // required
 	}
 
 	// DisputedEvent is emitted when a dispute was opened or updated.
@@ -47,7 +55,7 @@ type (
 		Phase  types.Phase // required
 		Cid    ChannelID
 		State  State
-		Topics []types.Hash // required
+		Topics Topics // required
 	}
 
 	// ProgressedEvent is emitted when a channel state was progressed.
@@ -56,24 +64,40 @@ type (
 		Cid     ChannelID
 		Version Version
 		App     AppID
-		Topics  []types.Hash // required
+		Topics  Topics // required
 	}
 
 	// ConcludedEvent is emitted when a channel is concluded.
 	ConcludedEvent struct {
 		Phase  types.Phase // required
 		Cid    ChannelID
-		Topics []types.Hash // required
+		Topics Topics // required
 	}
 
 	// WithdrawnEvent is emitted when all funds are withdrawn from a funding ID.
 	WithdrawnEvent struct {
 		Phase  types.Phase // required
 		Fid    FundingID
-		Topics []types.Hash // required
+		Topics Topics // required
 	}
 )
 
+// DecodeEventRecords decodes the raw event records of a block with the
+// metadata of the chain. The SCALE decoder of the RPC client panics on some
+// malformed records, these panics are returned as errors.
+func DecodeEventRecords(raw types.EventRecordsRaw, meta *types.Metadata) (records *EventRecords, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			records, err = nil, errors.Errorf("decoding event records: %v", r)
+		}
+	}()
+	records = new(EventRecords)
+	if err := raw.DecodeEventRecords(meta, records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
 // EventIsDeposited returns whether an event is a DepositedEvent.
 func EventIsDeposited(e PerunEvent) bool {
 	_, ok := e.(*DepositedEvent)
@@ -113,10 +137,11 @@ func EventIsWithdrawn(e PerunEvent) bool {
 	return ok
 }
 
-// Events extracts all Perun events into one slice and returns it.
+// Events extracts all Perun events into one slice and returns them in the
+// order in which they were emitted.
 func (r *EventRecords) Events() []PerunEvent {
 	var ret []PerunEvent
-	// The order here does not matter here since just one slice is non empty.
+	// The decoder groups the events by type, their order is restored below.
 	for _, e := range r.PerunModule_Deposited {
 		e := e
 		ret = append(ret, &e)
@@ -137,5 +162,38 @@ func (r *EventRecords) Events() []PerunEvent {
 		e := e
 		ret = append(ret, &e)
 	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return phaseOrder(EventPhase(ret[i])) < phaseOrder(EventPhase(ret[j]))
+	})
 	return ret
 }
+
+// EventPhase returns the phase of a Perun event.
+func EventPhase(e PerunEvent) types.Phase {
+	switch e := e.(type) {
+	case *DepositedEvent:
+		return e.Phase
+	case *DisputedEvent:
+		return e.Phase
+	case *ProgressedEvent:
+		return e.Phase
+	case *ConcludedEvent:
+		return e.Phase
+	case *WithdrawnEvent:
+		return e.Phase
+	default:
+		return types.Phase{}
+	}
+}
+
+// phaseOrder returns the position of a phase within its block.
+func phaseOrder(phase types.Phase) uint64 {
+	switch {
+	case phase.IsApplyExtrinsic:
+		return uint64(phase.AsApplyExtrinsic) + 1
+	case phase.IsFinalization:
+		return math.MaxUint64
+	default: // Initialization
+		return 0
+	}
+}
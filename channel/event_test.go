@@ -0,0 +1,47 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channel_test
+
+import (
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+)
+
+func TestEventRecords_Events(t *testing.T) {
+	ext := func(i uint32) types.Phase {
+		return types.Phase{IsApplyExtrinsic: true, AsApplyExtrinsic: i}
+	}
+	records := channel.EventRecords{
+		PerunModule_Deposited:  []channel.DepositedEvent{{Phase: ext(3)}, {Phase: ext(0)}},
+		PerunModule_Disputed:   []channel.DisputedEvent{{Phase: ext(1)}},
+		PerunModule_Progressed: []channel.ProgressedEvent{{Phase: ext(2)}},
+		PerunModule_Concluded:  []channel.ConcludedEvent{{Phase: types.Phase{IsFinalization: true}}},
+		PerunModule_Withdrawn:  []channel.WithdrawnEvent{{Phase: types.Phase{IsInitialization: true}}},
+	}
+
+	events := records.Events()
+	require.Len(t, events, 6)
+	// The events are ordered by their phase.
+	assert.IsType(t, &channel.WithdrawnEvent{}, events[0])
+	for i := 1; i <= 4; i++ {
+		assert.Equal(t, ext(uint32(i-1)), channel.EventPhase(events[i]))
+	}
+	assert.IsType(t, &channel.ConcludedEvent{}, events[5])
+}
@@ -0,0 +1,172 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channel_test
+
+//go:generate go run ./test/gengolden -out testdata
+
+import (
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	chtest "github.com/perun-network/perun-polkadot-backend/channel/test"
+	pkgsr25519 "github.com/perun-network/perun-polkadot-backend/pkg/sr25519"
+	"github.com/perun-network/perun-polkadot-backend/wallet/sr25519"
+)
+
+var goldenHashers = []channel.Hasher{channel.Keccak256, channel.Blake2b256}
+
+// TestGolden checks the encoding, IDs and signatures of the backend against
+// the golden vectors in testdata.
+func TestGolden(t *testing.T) {
+	for _, h := range goldenHashers {
+		t.Run(h.String(), func(t *testing.T) {
+			setHasher(t, h)
+			golden := loadGolden(t, h)
+			require.Equal(t, h.String(), golden.Hasher)
+			require.NotEmpty(t, golden.Vectors)
+
+			for _, vec := range golden.Vectors {
+				t.Run(vec.Name, func(t *testing.T) {
+					checkGoldenParams(t, h, vec.Params)
+					checkGoldenState(t, vec.State, vec.Params.Participants)
+					checkGoldenWithdrawal(t, vec.Withdrawal)
+					for _, f := range vec.Fundings {
+						checkGoldenFunding(t, f)
+					}
+				})
+			}
+		})
+	}
+}
+
+// TestGolden_UpToDate checks that the generator still produces the golden
+// vectors. Fails if the encoder changed or the vectors need regeneration.
+func TestGolden_UpToDate(t *testing.T) {
+	for _, h := range goldenHashers {
+		t.Run(h.String(), func(t *testing.T) {
+			setHasher(t, h)
+			want := loadGolden(t, h)
+			got, err := chtest.GenerateGolden()
+			require.NoError(t, err)
+			// Signatures are randomized and therefore not comparable.
+			stripSigs(want)
+			stripSigs(got)
+			assert.Equal(t, want, got, "run `go generate` in the channel package")
+		})
+	}
+}
+
+func checkGoldenParams(t *testing.T, h channel.Hasher, g chtest.GoldenParams) {
+	params := channel.Params{ChallengeDuration: g.ChallengeDuration}
+	copy(params.Nonce[:], g.Nonce)
+	copy(params.App[:], g.App)
+	for _, part := range g.Participants {
+		var ident channel.OffIdentity
+		copy(ident[:], part)
+		params.Participants = append(params.Participants, ident)
+	}
+
+	enc, err := channel.ScaleEncode(&params)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(g.Encoded), enc)
+	var dec channel.Params
+	require.NoError(t, channel.ScaleDecode(&dec, g.Encoded))
+	assert.Equal(t, params, dec)
+	cid := h.Hash(g.Encoded)
+	assert.Equal(t, []byte(g.ChannelID), cid[:])
+}
+
+func checkGoldenState(t *testing.T, g chtest.GoldenState, parts []chtest.Hex) {
+	state := channel.State{Version: g.Version, Final: g.Final, Data: g.Data}
+	copy(state.Channel[:], g.Channel)
+	for _, _bal := range g.Balances {
+		bal, ok := new(big.Int).SetString(_bal, 10)
+		require.True(t, ok)
+		b, err := channel.MakeBalance(bal)
+		require.NoError(t, err)
+		state.Balances = append(state.Balances, b)
+	}
+
+	enc, err := channel.ScaleEncode(&state)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(g.Encoded), enc)
+	var dec channel.State
+	require.NoError(t, channel.ScaleDecode(&dec, g.Encoded))
+	// Ensure that nil and []byte{} count as equal.
+	if len(dec.Data) == 0 && len(state.Data) == 0 {
+		dec.Data = state.Data
+	}
+	assert.Equal(t, state, dec)
+
+	require.Len(t, g.Sigs, len(parts))
+	for i, sig := range g.Sigs {
+		verifyGoldenSig(t, g.Encoded, sig, parts[i])
+	}
+}
+
+func checkGoldenWithdrawal(t *testing.T, g chtest.GoldenWithdrawal) {
+	var w channel.Withdrawal
+	copy(w.Channel[:], g.Channel)
+	copy(w.Part[:], g.Part)
+	copy(w.Receiver[:], g.Receiver)
+
+	enc, err := channel.ScaleEncode(&w)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(g.Encoded), enc)
+	verifyGoldenSig(t, g.Encoded, g.Sig, g.Part)
+}
+
+func checkGoldenFunding(t *testing.T, g chtest.GoldenFunding) {
+	var f channel.Funding
+	copy(f.Channel[:], g.Channel)
+	copy(f.Part[:], g.Part)
+
+	enc, err := channel.ScaleEncode(&f)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(g.Encoded), enc)
+	fid, err := f.ID()
+	require.NoError(t, err)
+	assert.Equal(t, []byte(g.FundingID), fid[:])
+}
+
+func verifyGoldenSig(t *testing.T, msg, sig, signer []byte) {
+	pk, err := pkgsr25519.NewPK(signer)
+	require.NoError(t, err)
+	ok, err := new(sr25519.Backend).VerifySignature(msg, sig, sr25519.NewAddressFromPK(pk))
+	require.NoError(t, err)
+	assert.True(t, ok, "invalid signature")
+}
+
+func loadGolden(t testing.TB, h channel.Hasher) *chtest.GoldenFile {
+	data, err := os.ReadFile(filepath.Join("testdata", chtest.GoldenFileName(h)))
+	require.NoError(t, err)
+	golden := new(chtest.GoldenFile)
+	require.NoError(t, json.Unmarshal(data, golden))
+	return golden
+}
+
+func stripSigs(golden *chtest.GoldenFile) {
+	for i := range golden.Vectors {
+		golden.Vectors[i].State.Sigs = nil
+		golden.Vectors[i].Withdrawal.Sig = nil
+	}
+}
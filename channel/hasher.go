@@ -0,0 +1,76 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Hasher identifies the hash function that the pallet uses to calculate
+// channel and funding IDs.
+// The numeric values match the `Hasher` constant of the pallet.
+type Hasher uint8
+
+const (
+	// Keccak256 hashes with Keccak-256 as known from Ethereum.
+	Keccak256 Hasher = iota
+	// Blake2b256 hashes with Blake2b-256 as used natively by Substrate.
+	Blake2b256
+
+	// DefaultHasher is the hasher that is used if no other was configured.
+	DefaultHasher = Keccak256
+)
+
+// ErrUnknownHasher the hasher is not supported.
+var ErrUnknownHasher = errors.New("unknown hasher")
+
+// Valid returns ErrUnknownHasher if the hasher is not supported.
+func (h Hasher) Valid() error {
+	switch h {
+	case Keccak256, Blake2b256:
+		return nil
+	default:
+		return errors.WithMessagef(ErrUnknownHasher, "value %d", uint8(h))
+	}
+}
+
+// Hash hashes the data with the hash function of the receiver.
+// Panics if the hasher is not valid.
+func (h Hasher) Hash(data []byte) [32]byte {
+	switch h {
+	case Keccak256:
+		return crypto.Keccak256Hash(data)
+	case Blake2b256:
+		return blake2b.Sum256(data)
+	default:
+		panic(fmt.Sprintf("unknown hasher: %d", uint8(h)))
+	}
+}
+
+// String returns the name of the hasher.
+func (h Hasher) String() string {
+	switch h {
+	case Keccak256:
+		return "Keccak-256"
+	case Blake2b256:
+		return "Blake2b-256"
+	default:
+		return fmt.Sprintf("Hasher(%d)", uint8(h))
+	}
+}
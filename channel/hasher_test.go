@@ -0,0 +1,88 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channel_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pkgtest "polycry.pt/poly-go/test"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+)
+
+// TestHasher_FundingID checks the funding ID of a fixed Funding for each
+// supported hasher. The SCALE encoding of a Funding is the concatenation of
+// its fixed-size fields, so the expected values are the plain Keccak-256 and
+// Blake2b-256 digests of 32 bytes 0x01 followed by 32 bytes 0x02.
+func TestHasher_FundingID(t *testing.T) {
+	var cid channel.ChannelID
+	var part channel.OffIdentity
+	copy(cid[:], bytes.Repeat([]byte{1}, len(cid)))
+	copy(part[:], bytes.Repeat([]byte{2}, len(part)))
+
+	data, err := channel.ScaleEncode(channel.NewFunding(cid, part))
+	require.NoError(t, err)
+	require.Equal(t, append(cid[:], part[:]...), data)
+
+	tests := []struct {
+		hasher channel.Hasher
+		want   string
+	}{
+		{channel.Keccak256, "0x346d8c96a2454213fcc0daff3c96ad0398148181b9fa6488f7ae2c0af5b20aa0"},
+		{channel.Blake2b256, "0x30b600fb1f0cc0b3f0fc28cdcb7389405a6659be81c7d5c5905725aa3a5119ce"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.hasher.String(), func(t *testing.T) {
+			setHasher(t, tc.hasher)
+
+			fid, err := channel.NewFunding(cid, part).ID()
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, hexutil.Encode(fid[:]))
+		})
+	}
+}
+
+// TestHasher_CalcID checks that the channel ID depends on the hasher.
+func TestHasher_CalcID(t *testing.T) {
+	s := newSetup(pkgtest.Prng(t))
+	params, err := channel.NewParams(s.Params)
+	require.NoError(t, err)
+	data, err := channel.ScaleEncode(params)
+	require.NoError(t, err)
+
+	for _, h := range []channel.Hasher{channel.Keccak256, channel.Blake2b256} {
+		setHasher(t, h)
+		assert.Equal(t, channel.ChannelID(h.Hash(data)), channel.CalcID(s.Params))
+	}
+}
+
+func TestHasher_Invalid(t *testing.T) {
+	before := channel.Backend.Hasher()
+	assert.ErrorIs(t, channel.Backend.SetHasher(channel.Hasher(0xff)), channel.ErrUnknownHasher)
+	assert.Equal(t, before, channel.Backend.Hasher())
+	assert.Panics(t, func() { channel.Hasher(0xff).Hash(nil) })
+}
+
+// setHasher configures the hasher and restores the previous one on cleanup.
+func setHasher(t *testing.T, h channel.Hasher) {
+	t.Helper()
+	before := channel.Backend.Hasher()
+	require.NoError(t, channel.Backend.SetHasher(h))
+	t.Cleanup(func() { require.NoError(t, channel.Backend.SetHasher(before)) })
+}
@@ -19,5 +19,5 @@ import (
 )
 
 func init() {
-	channel.SetBackend(new(backend))
+	channel.SetBackend(&Backend)
 }
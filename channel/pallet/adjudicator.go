@@ -16,6 +16,7 @@ package pallet
 
 import (
 	"context"
+	"sync"
 
 	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
 	"github.com/pkg/errors"
@@ -32,11 +33,25 @@ type Adjudicator struct {
 	log.Embedding
 
 	pallet     *Pallet
-	storage    substrate.StorageQueryer
+	storage    substrate.BlockQueryer
 	onChain    pwallet.Account
 	pastBlocks types.BlockNumber
+	// journal records all sent extrinsics, can be nil.
+	journal Journal
+	// receiver receives all withdrawals, defaults to onChain if nil.
+	receiver pwallet.Address
+	// receivers overrides the receiver per channel.
+	receivers   map[pchannel.ID]pwallet.Address
+	receiverMtx sync.Mutex
+	// retry configures the resubmission of failed extrinsics.
+	retry RetryPolicy
+	// dryRun executes extrinsics before submitting them.
+	dryRun bool
 }
 
+// AdjudicatorOpt configures an Adjudicator.
+type AdjudicatorOpt func(*Adjudicator)
+
 var (
 	// ErrConcludedDifferentVersion a channel was concluded with a different version.
 	ErrConcludedDifferentVersion = errors.New("channel was concluded with a different version")
@@ -46,9 +61,53 @@ var (
 	ErrReqVersionTooLow = errors.New("request version too low")
 )
 
+// WithAdjudicatorJournal configures a Journal that records all extrinsics
+// of the Adjudicator. Interrupted operations are then resumed when they are
+// retried instead of being sent twice.
+func WithAdjudicatorJournal(journal Journal) AdjudicatorOpt {
+	return func(a *Adjudicator) {
+		a.journal = journal
+	}
+}
+
+// WithReceiver configures the on-chain address that receives the funds of
+// all withdrawals of the Adjudicator. The fees are still paid by the
+// on-chain account of the Adjudicator. Defaults to the on-chain account.
+// See SetReceiver to configure the receiver per channel.
+func WithReceiver(receiver pwallet.Address) AdjudicatorOpt {
+	return func(a *Adjudicator) {
+		a.receiver = receiver
+	}
+}
+
 // NewAdjudicator returns a new Adjudicator.
-func NewAdjudicator(onChain pwallet.Account, pallet *Pallet, storage substrate.StorageQueryer, pastBlocks types.BlockNumber) *Adjudicator {
-	return &Adjudicator{log.MakeEmbedding(log.Default()), pallet, storage, onChain, pastBlocks}
+// The storage must be a BlockQueryer, not only a StorageQueryer as in earlier
+// versions, since events are read at their block. A *substrate.API
+// implements both.
+func NewAdjudicator(onChain pwallet.Account, pallet *Pallet, storage substrate.BlockQueryer, pastBlocks types.BlockNumber, opts ...AdjudicatorOpt) *Adjudicator {
+	ret := &Adjudicator{
+		Embedding:  log.MakeEmbedding(log.Default()),
+		pallet:     pallet,
+		storage:    storage,
+		onChain:    onChain,
+		pastBlocks: pastBlocks,
+		receivers:  make(map[pchannel.ID]pwallet.Address),
+		retry:      DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	return ret
+}
+
+// ResumePending resumes the disputes, progressions, conclusions and
+// withdrawals that the journal of the Adjudicator holds as pending, which is
+// the case if the process stopped while they were in flight, and waits for
+// them. Should be called once after a restart. Operations that fail are
+// recorded as failed. Does nothing without a journal, see
+// WithAdjudicatorJournal.
+func (a *Adjudicator) ResumePending(ctx context.Context) error {
+	return resumePending(ctx, a.pallet, a.journal, OpDispute, OpProgress, OpConclude, OpWithdraw)
 }
 
 // Register registers and disputes a channel.
@@ -58,21 +117,39 @@ func (a *Adjudicator) Register(ctx context.Context, req pchannel.AdjudicatorReq,
 	if err := a.checkRegister(req, states); err != nil {
 		return err
 	}
+	// Check the registered state, if any.
+	dis, err := a.pallet.QueryStateRegister(req.Params.ID(), a.storage, a.pastBlocks)
+	if errors.Is(err, ErrNoRegisteredState) {
+		return a.dispute(ctx, req)
+	} else if err != nil {
+		return err
+	}
+	if err := ValidateRegisterVersion(dis, req.Tx.Version); err != nil {
+		return err
+	}
+	now, err := chainNow(a.storage)
+	if err != nil {
+		return err
+	}
+	if err := ValidateRegisterable(dis, now); err != nil {
+		return err
+	}
 	// Execute dispute.
 	return a.dispute(ctx, req)
 }
 
-// Progress returns an error because app channels are currently not supported.
+// Progress progresses the registered state of an app channel. The transition
+// is checked against the registered state first, see ValidateProgress.
 func (a *Adjudicator) Progress(ctx context.Context, req pchannel.ProgressReq) error {
 	defer a.Log().Trace("Progress done")
 
-	err := a.waitProgressable(ctx, req.Params.ID())
+	from, err := a.waitProgressable(ctx, req)
 	if err != nil {
 		return err
 	}
 
 	// Build Dispute Tx.
-	ext, err := a.pallet.BuildProgress(a.onChain, req.Params, req.NewState, req.Sig, req.Idx)
+	ext, err := a.pallet.BuildProgress(a.onChain, req.Params, from, req.NewState, req.Sig, req.Idx)
 	if err != nil {
 		return err
 	}
@@ -86,7 +163,7 @@ func (a *Adjudicator) Progress(ctx context.Context, req pchannel.ProgressReq) er
 
 	// Send and wait for TX finalization.
 	a.Log().WithField("cid", req.Tx.ID).WithField("version", req.NewState.Version).Debug("Progress")
-	if err := a.call(ctx, ext); err != nil {
+	if err := a.call(ctx, OpKey{OpProgress, req.Params.ID()}, ext); err != nil {
 		return err
 	}
 
@@ -111,7 +188,7 @@ func (a *Adjudicator) dispute(ctx context.Context, req pchannel.AdjudicatorReq)
 	}
 	a.Log().WithField("cid", req.Tx.ID).WithField("version", req.Tx.Version).Debug("Dispute")
 	// Send and wait for TX finalization.
-	if err := a.call(ctx, ext); err != nil {
+	if err := a.call(ctx, OpKey{OpDispute, req.Params.ID()}, ext); err != nil {
 		return err
 	}
 	// Wait for disputed event.
@@ -147,22 +224,31 @@ loop:
 	}
 }
 
-func (a *Adjudicator) waitProgressable(ctx context.Context, ch pchannel.ID) error {
+// waitProgressable waits until the registered state can be progressed and
+// returns it.
+func (a *Adjudicator) waitProgressable(ctx context.Context, req pchannel.ProgressReq) (*pchannel.State, error) {
 	// Fetch on-chain dispute.
-	dis, err := a.pallet.QueryStateRegister(ch, a.storage, a.pastBlocks)
+	dis, err := a.pallet.QueryStateRegister(req.Params.ID(), a.storage, a.pastBlocks)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	// Check the transition before waiting since it cannot become valid.
+	from, err := channel.NewPerunState(&dis.State, req.Params.App)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateProgress(req.Params, from, req.NewState, req.Sig, req.Idx); err != nil {
+		return nil, err
 	}
 
 	// If we are in the register phase, we wait for the dispute timeout.
 	if dis.Phase == channel.RegisterPhase {
 		timeout := channel.MakeTimeout(dis.Timeout, a.storage)
-		err := timeout.Wait(ctx)
-		if err != nil {
-			return err
+		if err := timeout.Wait(ctx); err != nil {
+			return nil, err
 		}
 	}
-	return nil
+	return from, nil
 }
 
 // waitForProgressed blocks until a Progressed event with version greater or equal to
@@ -195,6 +281,7 @@ loop:
 }
 
 // Withdraw concludes a channel and withdraws all funds.
+// The funds are paid out to the receiver of the channel, see SetReceiver.
 func (a *Adjudicator) Withdraw(ctx context.Context, req pchannel.AdjudicatorReq, states pchannel.StateMap) error {
 	if len(states) != 0 {
 		return errors.New("sub-channels unsupported")
@@ -207,13 +294,69 @@ func (a *Adjudicator) Withdraw(ctx context.Context, req pchannel.AdjudicatorReq,
 	return a.withdraw(ctx, req)
 }
 
+// SetReceiver configures the on-chain address that receives the funds of
+// withdrawals from channel `cid`. Overrides WithReceiver. A nil receiver
+// removes the override. The override is also removed after the next
+// successful withdrawal from the channel.
+func (a *Adjudicator) SetReceiver(cid pchannel.ID, receiver pwallet.Address) {
+	a.receiverMtx.Lock()
+	defer a.receiverMtx.Unlock()
+
+	if receiver == nil {
+		delete(a.receivers, cid)
+	} else {
+		a.receivers[cid] = receiver
+	}
+}
+
+// receiverOf returns the on-chain address that receives the funds of
+// withdrawals from channel `cid`.
+func (a *Adjudicator) receiverOf(cid pchannel.ID) pwallet.Address {
+	a.receiverMtx.Lock()
+	defer a.receiverMtx.Unlock()
+
+	if receiver, ok := a.receivers[cid]; ok {
+		return receiver
+	} else if a.receiver != nil {
+		return a.receiver
+	}
+	return a.onChain.Address()
+}
+
 // withdraw sends and waits for a withdrawal extrinsic.
 func (a *Adjudicator) withdraw(ctx context.Context, req pchannel.AdjudicatorReq) error {
-	ext, err := a.pallet.BuildWithdraw(a.onChain, req.Acc, req.Tx.ID)
+	dis, err := a.pallet.QueryStateRegister(req.Tx.ID, a.storage, a.pastBlocks)
 	if err != nil {
 		return err
 	}
-	return a.call(ctx, ext)
+	if err := ValidateWithdrawable(dis); err != nil {
+		return err
+	}
+	receiver := a.receiverOf(req.Tx.ID)
+	ext, err := a.pallet.BuildWithdrawTo(a.onChain, req.Acc, req.Tx.ID, receiver)
+	if err != nil {
+		return err
+	}
+	fid, err := calcFid(req.Tx.ID, req.Acc.Address())
+	if err != nil {
+		return err
+	}
+	if err := a.call(ctx, OpKey{OpWithdraw, fid}, ext); err != nil {
+		return err
+	}
+	a.clearReceiver(req.Tx.ID, receiver)
+	return nil
+}
+
+// clearReceiver removes the receiver override of channel `cid` if it is
+// still `receiver`.
+func (a *Adjudicator) clearReceiver(cid pchannel.ID, receiver pwallet.Address) {
+	a.receiverMtx.Lock()
+	defer a.receiverMtx.Unlock()
+
+	if r, ok := a.receivers[cid]; ok && r.Equal(receiver) {
+		delete(a.receivers, cid)
+	}
 }
 
 // Subscribe subscribes to adjudicator events.
@@ -224,7 +367,7 @@ func (a *Adjudicator) Subscribe(ctx context.Context, cid pchannel.ID) (pchannel.
 // ensureConcluded ensures that a channel was concluded.
 func (a *Adjudicator) ensureConcluded(ctx context.Context, req pchannel.AdjudicatorReq) error {
 	// Indicates whether we can use concludeFinal.
-	concludeFinal := req.Tx.State.IsFinal && fullySignedTx(req.Tx, req.Params.Parts) == nil
+	concludeFinal := ValidateConcludeFinal(req.Params, req.Tx.State, req.Tx.Sigs) == nil
 
 	// Fetch on-chain dispute.
 	dis, err := a.pallet.QueryStateRegister(req.Params.ID(), a.storage, a.pastBlocks)
@@ -251,17 +394,16 @@ func (a *Adjudicator) ensureConcluded(ctx context.Context, req pchannel.Adjudica
 	// Build the Extrinisic.
 	ext, err := func() (*types.Extrinsic, error) {
 		if !concludeFinal {
-			// Wait for the dispute timeout. If the channel has an app, extend the
-			// timeout by one challenge duration.
-			timeout := dis.Timeout
-			if !pchannel.IsNoApp(req.Params.App) {
-				timeout += req.Params.ChallengeDuration
+			if err := a.waitConcludable(ctx, req.Params, dis); err != nil {
+				return nil, err
 			}
-			chTimeout := channel.MakeTimeout(timeout, a.storage)
-			if err := chTimeout.Wait(ctx); err != nil {
+			now, err := chainNow(a.storage)
+			if err != nil {
+				return nil, err
+			}
+			if err := ValidateConcludable(dis, now, progressWindow(req.Params)); err != nil {
 				return nil, err
 			}
-
 			return a.pallet.BuildConclude(a.onChain, req.Params)
 		}
 
@@ -271,20 +413,9 @@ func (a *Adjudicator) ensureConcluded(ctx context.Context, req pchannel.Adjudica
 		return err
 	}
 
-	// Setup the subscription for Concluded events.
-	sub, err := a.pallet.Subscribe(channel.EventIsConcluded(req.Params.ID()), a.pastBlocks)
-	if err != nil {
-		return err
-	}
-	defer sub.Close()
-
-	// Send the Extrinsic.
-	if err := a.call(ctx, ext); err != nil {
-		return err
-	}
-
-	// Wait for a concluded event that either we or some other party caused.
-	if err := a.waitForConcluded(ctx, sub, req.Tx.ID); err != nil {
+	// Send the Extrinsic and wait for a concluded event that either we or
+	// some other party caused.
+	if err := a.conclude(ctx, req.Params.ID(), ext); err != nil {
 		return err
 	}
 	// Fetch on-chain dispute again since the `Concluded` event
@@ -300,6 +431,31 @@ func (a *Adjudicator) ensureConcluded(ctx context.Context, req pchannel.Adjudica
 	return nil
 }
 
+// waitConcludable waits for the timeout of a registered dispute. If the
+// channel has an app, the timeout is extended by one challenge duration.
+func (a *Adjudicator) waitConcludable(ctx context.Context, params *pchannel.Params, dis *channel.RegisteredState) error {
+	return channel.MakeTimeout(dis.Timeout+progressWindow(params), a.storage).Wait(ctx)
+}
+
+// conclude sends a conclude Extrinsic and waits for a concluded event of the
+// channel.
+func (a *Adjudicator) conclude(ctx context.Context, cid channel.ChannelID, ext *types.Extrinsic) error {
+	// Setup the subscription for Concluded events.
+	sub, err := a.pallet.Subscribe(channel.EventIsConcluded(cid), a.pastBlocks)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	// Send the Extrinsic. Another party can conclude the channel first.
+	if err := a.call(ctx, OpKey{OpConclude, cid}, ext); errors.Is(err, ErrAlreadyConcluded) {
+		a.Log().WithField("cid", cid).Debug("Channel was concluded by another party")
+	} else if err != nil {
+		return err
+	}
+	return a.waitForConcluded(ctx, sub, cid)
+}
+
 // waitForConcluded waits for a concluded event of the specified channel.
 func (a *Adjudicator) waitForConcluded(ctx context.Context, sub *EventSub, id channel.ChannelID) error {
 	a.Log().Tracef("Waiting for conclude event")
@@ -323,19 +479,6 @@ loop:
 	}
 }
 
-// call sends an Extrinsic and waits for it to be finalized.
-// Does not indicate whether or not the call succeeded.
-// Use EventSubs to be sure that the call did not error.
-func (a *Adjudicator) call(ctx context.Context, ext *types.Extrinsic) error {
-	sub, err := a.pallet.Transact(ext)
-	if err != nil {
-		return err
-	}
-	defer sub.Close()
-	a.Log().Trace("waiting for TX confirmation")
-	return sub.WaitUntil(ctx, substrate.ExtIsFinal)
-}
-
 // checkRegister returns an `ErrAdjudicatorReqIncompatible` error if
 // the passed request cannot be handled by the Adjudicator.
 func (*Adjudicator) checkRegister(req pchannel.AdjudicatorReq, states []pchannel.SignedState) error {
@@ -350,18 +493,3 @@ func (*Adjudicator) checkRegister(req pchannel.AdjudicatorReq, states []pchannel
 		return nil
 	}
 }
-
-func fullySignedTx(tx pchannel.Transaction, parts []pwallet.Address) error {
-	if len(tx.Sigs) != len(parts) {
-		return errors.Errorf("wrong number of signatures")
-	}
-
-	for i, p := range parts {
-		if ok, err := pchannel.Verify(p, tx.State, tx.Sigs[i]); err != nil {
-			return errors.WithMessagef(err, "verifying signature[%d]", i)
-		} else if !ok {
-			return errors.Errorf("invalid signature[%d]", i)
-		}
-	}
-	return nil
-}
@@ -15,41 +15,65 @@
 package pallet
 
 import (
-	"fmt"
-	"time"
+	"bytes"
 
+	"github.com/centrifuge/go-substrate-rpc-client/v3/scale"
 	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
 	"github.com/perun-network/perun-polkadot-backend/channel"
 	pkg_sr25519 "github.com/perun-network/perun-polkadot-backend/pkg/sr25519"
 	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
 	"github.com/perun-network/perun-polkadot-backend/wallet/sr25519"
+	"github.com/pkg/errors"
 	pchannel "perun.network/go-perun/channel"
 	"perun.network/go-perun/log"
+	pwallet "perun.network/go-perun/wallet"
 	pkgsync "polycry.pt/poly-go/sync"
 )
 
 // AdjudicatorSub implements the AdjudicatorSubscription interface.
+// It returns every adjudicator event of a channel in chain order. Events that
+// are received twice are only returned once.
 type AdjudicatorSub struct {
 	*pkgsync.Closer
 	log.Embedding
 
-	cid     channel.ChannelID
-	sub     *EventSub
-	pallet  *Pallet
-	storage substrate.StorageQueryer
-	err     chan error
+	cid    channel.ChannelID
+	sub    *EventSub
+	pallet *Pallet
+	chain  *blockCache
+	seen   map[types.Hash]*seenBlock
+	last   types.BlockNumber // Number of the block of the last event.
+	err    chan error
+}
+
+// seenBlock contains the indices of the returned events of a block.
+type seenBlock struct {
+	number types.BlockNumber
+	events map[int]bool
 }
 
 // NewAdjudicatorSub returns a new AdjudicatorSub. Will return all events from
 // the `pastBlocks` past blocks and all events from future blocks.
-func NewAdjudicatorSub(cid channel.ChannelID, p *Pallet, storage substrate.StorageQueryer, pastBlocks types.BlockNumber) (*AdjudicatorSub, error) {
+// The chain must be a BlockQueryer, not only a StorageQueryer as in earlier
+// versions, since the events are read at their block. A *substrate.API
+// implements both.
+func NewAdjudicatorSub(cid channel.ChannelID, p *Pallet, chain substrate.BlockQueryer, pastBlocks types.BlockNumber) (*AdjudicatorSub, error) {
 	sub, err := p.Subscribe(isAdjEvent(cid), pastBlocks)
 	if err != nil {
 		return nil, err
 	}
-	ret := &AdjudicatorSub{new(pkgsync.Closer), log.MakeEmbedding(log.Default()), cid, sub, p, storage, make(chan error, 1)}
+	ret := &AdjudicatorSub{
+		Closer:    new(pkgsync.Closer),
+		Embedding: log.MakeEmbedding(log.Default()),
+		cid:       cid,
+		sub:       sub,
+		pallet:    p,
+		chain:     &blockCache{BlockQueryer: chain},
+		seen:      make(map[types.Hash]*seenBlock),
+		err:       make(chan error, 1),
+	}
 	ret.OnCloseAlways(func() {
-		if err := ret.sub.Close(); err != nil {
+		if err := ret.sub.Close(); err != nil && !pkgsync.IsAlreadyClosedError(err) {
 			ret.Log().WithError(err).Error("Could not close Closer.")
 		}
 		close(ret.err)
@@ -65,80 +89,190 @@ func isAdjEvent(cid channel.ChannelID) EventPredicate {
 	}
 }
 
-// Next implements the AdjudicatorSub.Next function.
+// Next implements the AdjudicatorSub.Next function. It blocks until the next
+// event arrives and returns nil when the subscription is closed or failed.
+// A RegisteredEvent has no state and signatures if the dispute was not
+// submitted as a direct call, for example through a proxy or in a batch.
 func (s *AdjudicatorSub) Next() pchannel.AdjudicatorEvent {
-	if s.IsClosed() {
-		return nil
+	for {
+		if s.IsClosed() {
+			return nil
+		}
+		var event BlockEvent
+		select {
+		case event = <-s.sub.BlockEvents():
+		case err := <-s.sub.Err():
+			s.fail(err)
+			return nil
+		case <-s.Closed():
+			return nil
+		}
+
+		if isNew, err := s.markSeen(event); err != nil {
+			s.fail(err)
+			return nil
+		} else if !isNew {
+			continue
+		}
+		s.Log().Tracef("AdjudicatorSub converting %T", event.Event)
+		adjEvent, err := makeAdjEvent(s.pallet, s.chain, event, nil)
+		if err != nil {
+			s.fail(err)
+			return nil
+		}
+		return adjEvent
 	}
-	var last channel.PerunEvent
-	// Wait for event or closed.
-	select {
-	case event := <-s.sub.Events():
-		if channel.EventIsDisputed(s.cid)(event) || channel.EventIsProgressed(s.cid)(event) || channel.EventIsConcluded(s.cid)(event) {
-			last = event
+}
+
+// markSeen records that an event is returned. Returns false if the event
+// was returned before or its block is older than the block of the last
+// event, since the events arrive in chain order. The entries of older blocks
+// are dropped.
+func (s *AdjudicatorSub) markSeen(e BlockEvent) (bool, error) {
+	if e.Block == (types.Hash{}) {
+		return true, nil
+	}
+	b, ok := s.seen[e.Block]
+	if !ok {
+		header, err := s.chain.Header(e.Block)
+		if err != nil {
+			return false, errors.WithMessagef(err, "reading header 0x%x", e.Block)
 		}
-	case <-s.Closed():
-		return nil
+		if header.Number < s.last {
+			return false, nil
+		}
+		b = &seenBlock{number: header.Number, events: make(map[int]bool)}
+		s.seen[e.Block] = b
+	}
+	if b.events[e.Index] {
+		return false, nil
 	}
+	b.events[e.Index] = true
 
-loop:
-	for {
-		select {
-		case event := <-s.sub.Events():
-			if channel.EventIsDisputed(s.cid)(event) || channel.EventIsProgressed(s.cid)(event) || channel.EventIsConcluded(s.cid)(event) {
-				last = event
+	if b.number > s.last {
+		s.last = b.number
+		for hash, old := range s.seen {
+			if old.number < s.last {
+				delete(s.seen, hash)
 			}
-		case <-s.Closed():
-			return nil
-		// wait until there is no new event for a specific time
-		case <-time.After(100 * time.Millisecond):
-			break loop
 		}
 	}
-	// Convert event.
-	event, err := s.makePerunEvent(last)
+	return true, nil
+}
+
+// blockCache caches the last block that was read, since all events of a
+// block arrive in a row and each of them reads the block.
+type blockCache struct {
+	substrate.BlockQueryer
+	hash  types.Hash
+	block *types.SignedBlock
+}
+
+// Block returns the block with the given hash and caches it.
+func (c *blockCache) Block(hash types.Hash) (*types.SignedBlock, error) {
+	if c.block != nil && c.hash == hash {
+		return c.block, nil
+	}
+	block, err := c.BlockQueryer.Block(hash)
+	if err != nil {
+		return nil, err
+	}
+	c.hash, c.block = hash, block
+	return block, nil
+}
+
+// fail closes the subscription with an error. The error can be nil.
+func (s *AdjudicatorSub) fail(err error) {
 	if err != nil {
 		s.err <- err
-		if err := s.Closer.Close(); err != nil {
-			s.Log().WithError(err).Error("Could not close Closer.")
-		}
-		return nil
 	}
-	return event
+	if err := s.Closer.Close(); err != nil && !pkgsync.IsAlreadyClosedError(err) {
+		s.Log().WithError(err).Error("Could not close Closer.")
+	}
+}
+
+// Err implements the AdjudicatorSub.Err function.
+func (s *AdjudicatorSub) Err() error {
+	return <-s.err
 }
 
-// makePerunEvent creates a Perun event from a generic event.
-func (s *AdjudicatorSub) makePerunEvent(event channel.PerunEvent) (pchannel.AdjudicatorEvent, error) {
-	switch event := event.(type) {
+// makeAdjEvent creates a go-perun adjudicator event from a Perun event.
+// The events carry the state of the block that emitted them. The state and
+// signatures of a RegisteredEvent are read from the dispute extrinsic of the
+// block, which needs the channel app. It is read from the extrinsic if `app`
+// is nil. If the extrinsic is not a direct dispute call, the event has no
+// signatures and only has a state if `app` is set.
+// Events without a block are built from the latest state.
+func makeAdjEvent(p *Pallet, chain substrate.BlockQueryer, e BlockEvent, app pchannel.App) (pchannel.AdjudicatorEvent, error) {
+	switch event := e.Event.(type) {
 	case *channel.DisputedEvent:
-		s.Log().Trace("AdjudicatorSub creating DisputedEvent")
-		dispute, err := s.pallet.QueryStateRegister(event.Cid, s.storage, 0)
+		dispute, err := queryRegisterAt(p, chain, event.Cid, e.Block)
 		if err != nil {
 			return nil, err
 		}
-
-		return &pchannel.RegisteredEvent{
+		var (
+			params channel.Params
+			sigs   []channel.Sig
+			state  channel.State
+		)
+		found, err := callArgs(p, chain, e, Dispute, &params, &state, &sigs)
+		if err != nil {
+			return nil, err
+		}
+		ret := &pchannel.RegisteredEvent{
 			AdjudicatorEventBase: pchannel.AdjudicatorEventBase{
 				IDV:      event.Cid,
 				VersionV: event.State.Version,
-				TimeoutV: channel.MakeTimeout(dispute.Timeout, s.storage),
+				TimeoutV: channel.MakeTimeout(dispute.Timeout, chain),
 			},
-			State: nil, // only needed for virtual channel support
-			Sigs:  nil, // only needed for virtual channel support
-		}, nil
+		}
+		if found && state.Channel == event.Cid && state.Version == event.State.Version {
+			if app == nil {
+				if app, err = resolveApp(params.App); err != nil {
+					return nil, err
+				}
+			}
+			ret.Sigs = make([]pwallet.Sig, len(sigs))
+			for i, sig := range sigs {
+				ret.Sigs[i] = append(pwallet.Sig(nil), sig[:]...)
+			}
+		} else {
+			p.Log().WithField("cid", event.Cid).Debug("Dispute extrinsic not found, RegisteredEvent has no signatures")
+		}
+		if app != nil {
+			if ret.State, err = channel.NewPerunState(&event.State, app); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
 	case *channel.ProgressedEvent:
-		s.Log().Trace("AdjudicatorSub creating ProgressedEvent")
-		dispute, err := s.pallet.QueryStateRegister(event.Cid, s.storage, 0)
+		dispute, err := queryRegisterAt(p, chain, event.Cid, e.Block)
 		if err != nil {
 			return nil, err
 		}
-
-		appPK, err := pkg_sr25519.NewPK(event.App[:])
+		app, err := resolveApp(event.App)
+		if err != nil {
+			return nil, err
+		}
+		var (
+			params channel.Params
+			next   channel.State
+			sig    channel.Sig
+			signer uint32
+		)
+		found, err := callArgs(p, chain, e, Progress, &params, &next, &sig, &signer)
 		if err != nil {
 			return nil, err
 		}
-		appAddr := sr25519.NewAddressFromPK(appPK)
-		app, err := pchannel.Resolve(appAddr)
+		if !found || next.Channel != event.Cid || next.Version != event.Version {
+			// The registered state of the block is exact unless the channel
+			// was progressed more than once in the block.
+			if dispute.State.Version != event.Version {
+				return nil, errors.Errorf("progressed state of version %d not found", event.Version)
+			}
+			next = dispute.State
+		}
+		state, err := channel.NewPerunState(&next, app)
 		if err != nil {
 			return nil, err
 		}
@@ -147,13 +281,12 @@ func (s *AdjudicatorSub) makePerunEvent(event channel.PerunEvent) (pchannel.Adju
 			AdjudicatorEventBase: pchannel.AdjudicatorEventBase{
 				IDV:      event.Cid,
 				VersionV: event.Version,
-				TimeoutV: channel.MakeTimeout(dispute.Timeout, s.storage),
+				TimeoutV: channel.MakeTimeout(dispute.Timeout, chain),
 			},
-			State: channel.NewPerunState(&dispute.State, app),
+			State: state,
 		}, nil
 	case *channel.ConcludedEvent:
-		s.Log().Trace("AdjudicatorSub creating ConcludedEvent")
-		dispute, err := s.pallet.QueryStateRegister(event.Cid, s.storage, 0)
+		dispute, err := queryRegisterAt(p, chain, event.Cid, e.Block)
 		if err != nil {
 			return nil, err
 		}
@@ -162,15 +295,63 @@ func (s *AdjudicatorSub) makePerunEvent(event channel.PerunEvent) (pchannel.Adju
 			AdjudicatorEventBase: pchannel.AdjudicatorEventBase{
 				IDV:      event.Cid,
 				VersionV: dispute.State.Version,
-				TimeoutV: channel.MakeTimeout(dispute.Timeout, s.storage),
+				TimeoutV: channel.MakeTimeout(dispute.Timeout, chain),
 			},
 		}, nil
 	default:
-		panic(fmt.Sprintf("unknown event: %#v", event))
+		return nil, errors.Errorf("unknown event: %#v", e.Event)
 	}
 }
 
-// Err implements the AdjudicatorSub.Err function.
-func (s *AdjudicatorSub) Err() error {
-	return <-s.err
+// queryRegisterAt returns the registered state of a channel at the end of a
+// block or the latest one if the block is zero.
+func queryRegisterAt(p *Pallet, chain substrate.BlockQueryer, cid channel.ChannelID, block types.Hash) (*channel.RegisteredState, error) {
+	if block == (types.Hash{}) {
+		return p.QueryStateRegister(cid, chain, 0)
+	}
+	return p.QueryStateRegisterAt(cid, chain, block)
+}
+
+// callArgs decodes the arguments of the extrinsic that emitted the event.
+// Returns false if the event has no block or was not emitted by a direct
+// call of `name`.
+func callArgs(p *Pallet, chain substrate.BlockQueryer, e BlockEvent, name *substrate.ExtName, args ...interface{}) (bool, error) {
+	phase := channel.EventPhase(e.Event)
+	if e.Block == (types.Hash{}) || !phase.IsApplyExtrinsic {
+		return false, nil
+	}
+	index, err := p.meta.FindCallIndex(name.String())
+	if err != nil {
+		return false, err
+	}
+	block, err := chain.Block(e.Block)
+	if err != nil {
+		return false, errors.WithMessagef(err, "reading block 0x%x", e.Block)
+	}
+	if int(phase.AsApplyExtrinsic) >= len(block.Block.Extrinsics) {
+		return false, errors.Errorf("block 0x%x has no extrinsic %d", e.Block, phase.AsApplyExtrinsic)
+	}
+	call := block.Block.Extrinsics[phase.AsApplyExtrinsic].Method
+	if call.CallIndex != index {
+		return false, nil
+	}
+	dec := scale.NewDecoder(bytes.NewReader(call.Args))
+	for _, arg := range args {
+		if err := dec.Decode(arg); err != nil {
+			return false, errors.WithMessagef(err, "decoding %v arguments", name)
+		}
+	}
+	return true, nil
+}
+
+// resolveApp returns the app with the given ID.
+func resolveApp(id channel.AppID) (pchannel.App, error) {
+	if id == (channel.AppID{}) {
+		return pchannel.NoApp(), nil
+	}
+	appPK, err := pkg_sr25519.NewPK(id[:])
+	if err != nil {
+		return nil, err
+	}
+	return pchannel.Resolve(sr25519.NewAddressFromPK(appPK))
 }
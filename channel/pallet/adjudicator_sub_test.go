@@ -47,6 +47,9 @@ func TestAdjudicatorSub_Register(t *testing.T) {
 	// Wait for one Registered event
 	event := sub.Next().(*pchannel.RegisteredEvent)
 	assert.Equal(t, params.ID(), event.IDV)
+	// The event contains the registered state and signatures.
+	assert.NoError(t, req.Tx.State.Equal(event.State))
+	assert.Equal(t, req.Tx.Sigs, event.Sigs)
 	// No second event should be emitted
 	ctxtest.AssertNotTerminates(t, 2*s.BlockTime, func() { sub.Next() })
 	// Sub returns nil after close
@@ -57,6 +60,32 @@ func TestAdjudicatorSub_Register(t *testing.T) {
 	assert.Nil(t, sub.Err())
 }
 
+// TestAdjudicatorSub_InOrder checks that every dispute is returned in chain
+// order, also when the events arrive in quick succession.
+func TestAdjudicatorSub_InOrder(t *testing.T) {
+	s := test.NewSetup(t)
+	adj := pallet.NewAdjudicator(s.Alice.Acc, s.Pallet, s.API, test.PastBlocks)
+	req, params, _ := newInitialAdjReq(s, 60)
+	ctx := s.NewCtx()
+	sub, err := adj.Subscribe(ctx, params.ID())
+	require.NoError(t, err)
+
+	const versions = 3
+	for v := 0; v < versions; v++ {
+		require.NoError(t, adj.Register(ctx, req, nil))
+		req.Tx = nextVersion(s, req.Tx)
+	}
+	for v := uint64(0); v < versions; v++ {
+		event := sub.Next().(*pchannel.RegisteredEvent)
+		assert.Equal(t, v, event.Version())
+		require.NotNil(t, event.State)
+		assert.Equal(t, v, event.State.Version)
+		assert.Len(t, event.Sigs, len(params.Parts))
+	}
+	ctxtest.AssertNotTerminates(t, 2*s.BlockTime, func() { sub.Next() })
+	assert.NoError(t, sub.Close())
+}
+
 func TestAdjudicatorSub_ConcludeFinal(t *testing.T) {
 	s := test.NewSetup(t)
 	adj := pallet.NewAdjudicator(s.Alice.Acc, s.Pallet, s.API, test.PastBlocks)
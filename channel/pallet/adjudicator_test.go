@@ -16,16 +16,23 @@ package pallet_test
 
 import (
 	"context"
+	"math/big"
 	"testing"
 
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	pchannel "perun.network/go-perun/channel"
+	pchtest "perun.network/go-perun/channel/test"
+	pwallet "perun.network/go-perun/wallet"
 
 	"github.com/perun-network/perun-polkadot-backend/channel"
 	"github.com/perun-network/perun-polkadot-backend/channel/pallet"
 	"github.com/perun-network/perun-polkadot-backend/channel/pallet/test"
 	chtest "github.com/perun-network/perun-polkadot-backend/channel/test"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/perun-network/perun-polkadot-backend/wallet"
+	wallettest "github.com/perun-network/perun-polkadot-backend/wallet/sr25519/test"
 )
 
 func TestAdjudicator_NotRegistered(t *testing.T) {
@@ -124,3 +131,188 @@ func TestAdjudicator_Walkthrough(t *testing.T) {
 		require.NoError(t, adjAlice.Withdraw(ctx, req, nil))
 	}
 }
+
+// TestAdjudicator_WithdrawReceiver checks that withdrawals are paid to the
+// configured receiver while the on-chain account pays the fees.
+func TestAdjudicator_WithdrawReceiver(t *testing.T) {
+	s := test.NewSetup(t)
+	req, params, state := newAdjReq(s, true)
+	// The share of Alice must be large enough to create a new account.
+	ed, err := s.API.ExistentialDeposit()
+	require.NoError(t, err)
+	state.Balances[0][0] = new(big.Int).Add(ed, state.Balances[0][0])
+	wState, err := channel.NewState(state)
+	require.NoError(t, err)
+	req.Tx.Sigs = s.SignState(wState)
+	dSetup := chtest.NewDepositSetup(params, state)
+	ctx := s.NewCtx()
+	require.NoError(t, test.FundAll(ctx, s.Funders, dSetup.FReqs))
+
+	// Bob pays the fees and the funds go to a new cold account.
+	cold := wallettest.NewWallet().NewRandomAccount(s.Rng).Address()
+	adj := pallet.NewAdjudicator(s.Bob.Acc, s.Pallet, s.API, test.PastBlocks, pallet.WithReceiver(cold))
+	alice := wallet.AsAddr(s.Alice.Acc.Address()).AccountID()
+	bob := wallet.AsAddr(s.Bob.Acc.Address()).AccountID()
+	maxFee := new(big.Int).SetUint64(2 * test.DefaultExtFee)
+	deltas := freeBalanceDeltas(t, s, func() {
+		require.NoError(t, adj.Withdraw(ctx, req, nil))
+	}, alice, bob)
+	assert.Zero(t, deltas[0].Sign(), "Alice must not pay")
+	assertFee(t, deltas[1], maxFee)
+	info, err := s.API.AccountInfo(wallet.AsAddr(cold).AccountID())
+	require.NoError(t, err)
+	assert.Equal(t, dSetup.FinalBals[0], info.Free.Int)
+
+	// The receiver of the channel overrides the receiver of the adjudicator.
+	adj.SetReceiver(state.ID, s.Alice.Acc.Address())
+	req.Acc, req.Idx = s.Bob.Acc, 1
+	deltas = freeBalanceDeltas(t, s, func() {
+		require.NoError(t, adj.Withdraw(ctx, req, nil))
+	}, alice, bob)
+	assert.Equal(t, dSetup.FinalBals[1], deltas[0], "Alice receives the funds without fee")
+	assertFee(t, deltas[1], maxFee)
+	// The override is removed after the withdrawal.
+	assert.True(t, cold.Equal(adj.ReceiverOf(state.ID)))
+}
+
+// freeBalanceDeltas returns by how much the free balances of the accounts
+// increased while `f` ran.
+func freeBalanceDeltas(t *testing.T, s *test.Setup, f func(), accs ...types.AccountID) []*big.Int {
+	balances := func() []*big.Int {
+		ret := make([]*big.Int, len(accs))
+		for i, acc := range accs {
+			info, err := s.API.AccountInfo(acc)
+			require.NoError(t, err)
+			ret[i] = info.Free.Int
+		}
+		return ret
+	}
+	before := balances()
+	f()
+	after := balances()
+	for i := range after {
+		after[i].Sub(after[i], before[i])
+	}
+	return after
+}
+
+// assertFee checks that a balance delta is a fee of at most `max`.
+func assertFee(t *testing.T, delta, max *big.Int) {
+	t.Helper()
+	assert.Negative(t, delta.Sign(), "no fee was paid")
+	assert.True(t, new(big.Int).Neg(delta).Cmp(max) <= 0, "fee %v exceeds %v", new(big.Int).Neg(delta), max)
+}
+
+// TestAdjudicator_RecoverFunding checks that a participant gets its deposit
+// back after the funding timed out and that the recovery can be re-run.
+func TestAdjudicator_RecoverFunding(t *testing.T) {
+	s := test.NewSetup(t)
+	req, params, state := newInitialAdjReq(s, 2)
+	dSetup := chtest.NewDepositSetup(params, state)
+	adj := pallet.NewAdjudicator(s.Alice.Acc, s.Pallet, s.API, test.PastBlocks)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*s.BlockTime)
+	defer cancel()
+
+	// Only Alice funds and times out.
+	funder := pallet.NewFunder(s.Pallet, s.Alice.Acc, s.API, test.PastBlocks,
+		pallet.WithFundingTimeout(pallet.BlockFundingTimeout(3, s.API)))
+	err := funder.Fund(ctx, *dSetup.FReqs[0])
+	require.True(t, pchannel.IsFundingTimeoutError(err))
+	s.AssertDeposit(dSetup.FIDs[0], dSetup.FinalBals[0])
+
+	// Alice recovers her deposit.
+	alice := wallet.AsAddr(s.Alice.Acc.Address()).AccountID()
+	gain := new(big.Int).Neg(dSetup.FinalBals[0])
+	epsilon := new(big.Int).SetUint64(3 * test.DefaultExtFee)
+	s.AssertBalanceChanges(map[types.AccountID]*big.Int{alice: gain}, epsilon, func() {
+		require.NoError(t, adj.RecoverFunding(ctx, params, req.Tx, s.Alice.Acc))
+	})
+	// Running the recovery again does nothing.
+	s.AssertBalanceChanges(map[types.AccountID]*big.Int{alice: big.NewInt(0)}, epsilon, func() {
+		require.NoError(t, adj.RecoverFunding(ctx, params, req.Tx, s.Alice.Acc))
+	})
+
+	// Only the initial state can be used.
+	req.Tx.State = req.Tx.State.Clone()
+	req.Tx.Version = 1
+	assert.ErrorIs(t, adj.RecoverFunding(ctx, params, req.Tx, s.Alice.Acc), pallet.ErrNotInitialState)
+}
+
+// TestPallet_ResignPending checks that a resigned Extrinsic does not reuse
+// the nonce of a pending Extrinsic of the account.
+func TestPallet_ResignPending(t *testing.T) {
+	s := test.NewSetup(t)
+	params, state := s.NewRandomParamAndState()
+	dSetup := chtest.NewDepositSetup(params, state, s.Alice.Acc)
+	req := dSetup.DReqs[0]
+	alice := wallet.AsAddr(s.Alice.Acc.Address()).AccountID()
+
+	ext, err := s.Pallet.BuildDeposit(req.Payer, req.Balance, req.FundingID)
+	require.NoError(t, err)
+	sub, err := s.Pallet.Transact(ext)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	resigned, err := s.Pallet.Resign(ext, alice, wallet.AsAcc(s.Alice.Acc), big.NewInt(1))
+	require.NoError(t, err)
+	assert.Equal(t, substrate.ExtNonce(ext)+1, substrate.ExtNonce(resigned))
+	require.NoError(t, sub.WaitUntil(s.NewCtx(), substrate.ExtIsFinal))
+}
+
+// newInitialAdjReq returns a request for the initial state of a new channel
+// with the given challenge duration.
+func newInitialAdjReq(s *test.Setup, challengeDuration uint64) (pchannel.AdjudicatorReq, *pchannel.Params, *pchannel.State) {
+	state := pchtest.NewRandomState(s.Rng, chtest.DefaultRandomOpts())
+	state.IsFinal = false
+	state.Version = 0
+	var data [20]byte
+	s.Rng.Read(data[:])
+	nonce := pchannel.NonceFromBytes(data[:])
+	params, err := pchannel.NewParams(challengeDuration, []pwallet.Address{s.Alice.Acc.Address(), s.Bob.Acc.Address()}, pchannel.NoApp(), nonce, true, false)
+	require.NoError(s.T, err)
+	state.ID = params.ID()
+	wState, err := channel.NewState(state)
+	require.NoError(s.T, err)
+	req := pchannel.AdjudicatorReq{
+		Params: params,
+		Acc:    s.Alice.Acc,
+		Tx:     pchannel.Transaction{State: state, Sigs: s.SignState(wState)},
+	}
+	return req, params, state
+}
+
+// TestPallet_ChannelStatus checks the status of a channel while it is
+// funded, disputed, concluded and withdrawn.
+func TestPallet_ChannelStatus(t *testing.T) {
+	s := test.NewSetup(t)
+	req, params, state := newAdjReq(s, true)
+	dSetup := chtest.NewDepositSetup(params, state)
+	ctx := s.NewCtx()
+
+	status, err := s.Pallet.ChannelStatus(params, s.API)
+	require.NoError(t, err)
+	assert.False(t, status.Registered)
+	assert.Equal(t, pallet.ActionDispute, status.NextAction)
+	for _, dep := range status.Deposits {
+		assert.Zero(t, dep.Sign())
+	}
+
+	require.NoError(t, test.FundAll(ctx, s.Funders, dSetup.FReqs))
+	status, err = s.Pallet.ChannelStatus(params, s.API)
+	require.NoError(t, err)
+	for i, dep := range status.Deposits {
+		assert.Zero(t, dep.Cmp(state.Balances[0][i]))
+		assert.False(t, status.Withdrawn[i])
+	}
+
+	// Alice concludes and withdraws.
+	adj := pallet.NewAdjudicator(s.Alice.Acc, s.Pallet, s.API, test.PastBlocks)
+	require.NoError(t, adj.Withdraw(ctx, req, nil))
+	status, err = s.Pallet.ChannelStatus(params, s.API)
+	require.NoError(t, err)
+	assert.True(t, status.Registered)
+	assert.Equal(t, channel.ConcludePhase, status.Phase)
+	assert.Equal(t, state.Version, status.Version)
+	assert.Equal(t, []bool{true, false}, status.Withdrawn)
+	assert.Equal(t, pallet.ActionWithdraw, status.NextAction)
+}
@@ -0,0 +1,179 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/pkg/errors"
+	pchannel "perun.network/go-perun/channel"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+)
+
+type (
+	// ChannelStatus describes where a channel stands on-chain.
+	ChannelStatus struct {
+		// Registered is false if no state was registered for the channel.
+		// Phase, Version, Timeout and Remaining are zero then.
+		Registered bool
+		// Phase is the phase of the dispute.
+		Phase channel.DisputePhase
+		// Version is the version of the registered state.
+		Version channel.Version
+		// Timeout is the on-chain dispute timeout.
+		Timeout channel.ChallengeDuration
+		// Remaining is the chain time until the next deadline in seconds or
+		// blocks, depending on the TimeoutMode. The deadline is the dispute
+		// timeout while the state can be refuted and the end of the
+		// progression window of app channels afterwards. Zero if no
+		// deadline is pending.
+		Remaining channel.ChallengeDuration
+		// Deposits contains the deposit of each participant.
+		Deposits []pchannel.Bal
+		// Withdrawn contains for each participant whether the channel is
+		// concluded and no funds of the participant are left.
+		Withdrawn []bool
+		// NextAction is the next action that the pallet accepts.
+		NextAction ChannelAction
+	}
+
+	// ChannelAction is an action that can be taken on a channel on-chain.
+	ChannelAction uint8
+)
+
+const (
+	// ActionNone nothing is left to do.
+	ActionNone ChannelAction = iota
+	// ActionDispute a state can be registered.
+	ActionDispute
+	// ActionRefute the registered state can be refuted with a newer state.
+	ActionRefute
+	// ActionProgress the app channel can be progressed.
+	ActionProgress
+	// ActionConclude the channel can be concluded.
+	ActionConclude
+	// ActionWithdraw the funds of some participants can be withdrawn.
+	ActionWithdraw
+)
+
+// ChannelStatus returns the on-chain status of a channel. The deposits are
+// identified by the funding IDs of the participants, which is why the params
+// are needed.
+func (p *Pallet) ChannelStatus(params *pchannel.Params, storage substrate.StorageQueryer) (*ChannelStatus, error) {
+	cid := params.ID()
+	status := &ChannelStatus{
+		Deposits:  make([]pchannel.Bal, len(params.Parts)),
+		Withdrawn: make([]bool, len(params.Parts)),
+	}
+
+	dis, err := p.QueryStateRegister(cid, storage, 0)
+	if err != nil && !errors.Is(err, ErrNoRegisteredState) {
+		return nil, err
+	}
+	concluded := err == nil && dis.Phase == channel.ConcludePhase
+
+	fids, err := calcFids(pchannel.FundingReq{Params: params, State: &pchannel.State{ID: cid}})
+	if err != nil {
+		return nil, err
+	}
+	for fid, idx := range fids {
+		dep, err := p.QueryDeposit(fid, storage, 0)
+		if errors.Is(err, ErrNoDeposit) {
+			status.Deposits[idx] = new(big.Int)
+		} else if err != nil {
+			return nil, err
+		} else {
+			status.Deposits[idx] = channel.MakePerunBalance(*dep)
+		}
+		status.Withdrawn[idx] = concluded && status.Deposits[idx].Sign() == 0
+	}
+
+	if dis == nil {
+		status.update(nil, 0, 0)
+		return status, nil
+	}
+	now, err := chainNow(storage)
+	if err != nil {
+		return nil, err
+	}
+	status.update(dis, now, progressWindow(params))
+	return status, nil
+}
+
+// update sets the dispute fields and the next action of the status.
+// `window` is the length of the progression window after the dispute
+// timeout, which is zero for channels without app.
+func (s *ChannelStatus) update(dis *channel.RegisteredState, now, window channel.ChallengeDuration) {
+	if dis == nil {
+		s.NextAction = ActionDispute
+		return
+	}
+	s.Registered = true
+	s.Phase = dis.Phase
+	s.Version = dis.State.Version
+	s.Timeout = dis.Timeout
+
+	concludable := dis.Timeout + window
+	switch {
+	case dis.Phase == channel.ConcludePhase:
+		s.NextAction = ActionNone
+		for _, withdrawn := range s.Withdrawn {
+			if !withdrawn {
+				s.NextAction = ActionWithdraw
+			}
+		}
+	case dis.Phase == channel.RegisterPhase && now < dis.Timeout:
+		s.NextAction = ActionRefute
+		s.Remaining = dis.Timeout - now
+	case now < concludable:
+		s.NextAction = ActionProgress
+		s.Remaining = concludable - now
+	default:
+		s.NextAction = ActionConclude
+	}
+}
+
+// chainNow returns the current chain time in the unit of the TimeoutMode.
+func chainNow(storage substrate.StorageQueryer) (channel.ChallengeDuration, error) {
+	if channel.Backend.TimeoutMode() == channel.BlockTimeout {
+		now, err := substrate.ChainBlockNumber(storage)
+		return channel.ChallengeDuration(now), err
+	}
+	now, err := substrate.ChainTime(storage)
+	return channel.ChallengeDuration(now.Unix()), err
+}
+
+// String returns the name of the action.
+func (a ChannelAction) String() string {
+	switch a {
+	case ActionNone:
+		return "None"
+	case ActionDispute:
+		return "Dispute"
+	case ActionRefute:
+		return "Refute"
+	case ActionProgress:
+		return "Progress"
+	case ActionConclude:
+		return "Conclude"
+	case ActionWithdraw:
+		return "Withdraw"
+	default:
+		return fmt.Sprintf("ChannelAction(%d)", uint8(a))
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+)
+
+func TestChannelStatus_Update(t *testing.T) {
+	registered := func(phase channel.DisputePhase) *channel.RegisteredState {
+		return &channel.RegisteredState{Phase: phase, State: channel.State{Version: 3}, Timeout: 100}
+	}
+	tests := []struct {
+		name      string
+		dis       *channel.RegisteredState
+		now       channel.ChallengeDuration
+		window    channel.ChallengeDuration
+		withdrawn []bool
+		action    ChannelAction
+		remaining channel.ChallengeDuration
+	}{
+		{"not registered", nil, 0, 0, nil, ActionDispute, 0},
+		{"refutable", registered(channel.RegisterPhase), 40, 0, nil, ActionRefute, 60},
+		{"refutable app", registered(channel.RegisterPhase), 40, 50, nil, ActionRefute, 60},
+		{"timed out", registered(channel.RegisterPhase), 100, 0, nil, ActionConclude, 0},
+		{"progressable", registered(channel.RegisterPhase), 120, 50, nil, ActionProgress, 30},
+		{"progressed", registered(channel.ProgressPhase), 90, 50, nil, ActionProgress, 60},
+		{"progress ended", registered(channel.ProgressPhase), 150, 50, nil, ActionConclude, 0},
+		{"concluded", registered(channel.ConcludePhase), 200, 0, []bool{true, false}, ActionWithdraw, 0},
+		{"withdrawn", registered(channel.ConcludePhase), 200, 0, []bool{true, true}, ActionNone, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			status := &ChannelStatus{Withdrawn: tc.withdrawn}
+			status.update(tc.dis, tc.now, tc.window)
+			assert.Equal(t, tc.action, status.NextAction, status.NextAction.String())
+			assert.Equal(t, tc.remaining, status.Remaining)
+			assert.Equal(t, tc.dis != nil, status.Registered)
+			if tc.dis != nil {
+				assert.Equal(t, tc.dis.Phase, status.Phase)
+				assert.Equal(t, channel.Version(3), status.Version)
+				assert.Equal(t, channel.ChallengeDuration(100), status.Timeout)
+			}
+		})
+	}
+}
@@ -18,7 +18,6 @@ import (
 	"context"
 
 	"github.com/perun-network/perun-polkadot-backend/channel"
-	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
 	"github.com/pkg/errors"
 	pchannel "perun.network/go-perun/channel"
 	"perun.network/go-perun/log"
@@ -31,13 +30,27 @@ type (
 		log.Embedding
 
 		pallet *Pallet
+		// journal records all sent extrinsics, can be nil.
+		journal Journal
 	}
 
+	// DepositorOpt configures a Depositor.
+	DepositorOpt func(*Depositor)
+
 	// DepositReq contains values to specify a Deposit.
+	// The FundingID identifies the off-chain participant that is credited,
+	// while the Payer is the on-chain account that signs the extrinsic. The
+	// Payer pays the Balance and the fees and does not need to be related to
+	// the participant, which allows to sponsor deposits.
 	DepositReq struct {
 		Balance   pchannel.Bal
-		Account   pwallet.Account
+		Payer     pwallet.Account
 		FundingID channel.FundingID
+
+		// Account is the payer of the deposit and only used if Payer is nil.
+		//
+		// Deprecated: Use Payer instead.
+		Account pwallet.Account
 	}
 )
 
@@ -45,15 +58,27 @@ type (
 var ErrFundingReqIncompatible = errors.New("incompatible funding request")
 
 // NewDepositReq returns a new DepositReq.
-func NewDepositReq(bal pchannel.Bal, acc pwallet.Account, fid channel.FundingID) *DepositReq {
-	return &DepositReq{bal, acc, fid}
+func NewDepositReq(bal pchannel.Bal, payer pwallet.Account, fid channel.FundingID) *DepositReq {
+	return &DepositReq{Balance: bal, Payer: payer, FundingID: fid}
+}
+
+// payer returns the Payer of the request and falls back to the deprecated
+// Account field.
+func (r *DepositReq) payer() pwallet.Account {
+	if r.Payer != nil {
+		return r.Payer
+	}
+	return r.Account
 }
 
 // NewDepositReqFromPerun returns a new deposit request from a Perun
-// funding request.
-func NewDepositReqFromPerun(req *pchannel.FundingReq, acc pwallet.Account) (*DepositReq, error) {
-	if !req.Agreement.Equal(req.State.Balances) && (len(req.Agreement) == 1) {
-		return nil, ErrFundingReqIncompatible
+// funding request. The funding ID is derived from the participant of the
+// request and the deposit is paid by `payer`.
+// The deposited amount is the share of the participant in the funding
+// agreement, which can differ from the balances of the initial state.
+func NewDepositReqFromPerun(req *pchannel.FundingReq, payer pwallet.Account) (*DepositReq, error) {
+	if err := checkAgreement(req); err != nil {
+		return nil, errors.WithMessage(ErrFundingReqIncompatible, err.Error())
 	}
 	bal := req.Agreement[0][req.Idx]
 	fReq, err := channel.MakeFundingReq(req)
@@ -61,26 +86,60 @@ func NewDepositReqFromPerun(req *pchannel.FundingReq, acc pwallet.Account) (*Dep
 		return nil, errors.WithMessage(ErrFundingReqIncompatible, err.Error())
 	}
 	fid, err := fReq.ID()
-	return NewDepositReq(bal, acc, fid), err
+	return NewDepositReq(bal, payer, fid), err
+}
+
+// checkAgreement checks that the funding agreement contains one valid
+// balance per participant for the single asset of the backend.
+func checkAgreement(req *pchannel.FundingReq) error {
+	if req.Params == nil || req.State == nil {
+		return errors.New("missing params or state")
+	}
+	if len(req.Agreement) != 1 {
+		return errors.Errorf("agreement has %d assets, expected 1", len(req.Agreement))
+	}
+	if len(req.Agreement[0]) != len(req.Params.Parts) {
+		return errors.Errorf("agreement has %d balances for %d participants", len(req.Agreement[0]), len(req.Params.Parts))
+	}
+	for i, bal := range req.Agreement[0] {
+		if _, err := channel.MakeBalance(bal); err != nil {
+			return errors.WithMessagef(err, "agreement of participant %d", i)
+		}
+	}
+	return nil
+}
+
+// WithDepositorJournal configures a Journal that records all deposits. An
+// interrupted deposit is then resumed when it is retried instead of being
+// sent twice.
+func WithDepositorJournal(journal Journal) DepositorOpt {
+	return func(d *Depositor) {
+		d.journal = journal
+	}
 }
 
 // NewDepositor returns a new Depositor.
-func NewDepositor(pallet *Pallet) *Depositor {
-	return &Depositor{log.MakeEmbedding(log.Default()), pallet}
+func NewDepositor(pallet *Pallet, opts ...DepositorOpt) *Depositor {
+	ret := &Depositor{log.MakeEmbedding(log.Default()), pallet, nil}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	return ret
 }
 
 // Deposit deposits funds into a channel as specified by the request.
+// Returns substrate.ErrInsufficientFunds before signing anything if the
+// payer cannot afford the deposit.
 // Returns as soon as the transaction was finalized; this does not guarantee success.
 func (d *Depositor) Deposit(ctx context.Context, req *DepositReq) error {
-	ext, err := d.pallet.BuildDeposit(req.Account, req.Balance, req.FundingID)
-	if err != nil {
+	payer := req.payer()
+	if err := d.pallet.CheckDeposit(payer, req.Balance, req.FundingID); err != nil {
 		return err
 	}
-	d.Log().WithField("fid", req.FundingID).Debugf("Depositing %v", req.Balance)
-	tx, err := d.pallet.Transact(ext)
+	ext, err := d.pallet.BuildDeposit(payer, req.Balance, req.FundingID)
 	if err != nil {
 		return err
 	}
-	defer tx.Close()
-	return tx.WaitUntil(ctx, substrate.ExtIsFinal)
+	d.Log().WithField("fid", req.FundingID).Debugf("Depositing %v", req.Balance)
+	return transact(ctx, d.pallet, d.journal, OpKey{OpDeposit, req.FundingID}, ext)
 }
@@ -15,11 +15,22 @@
 package pallet_test
 
 import (
+	"math/big"
 	"testing"
 
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet"
 	"github.com/perun-network/perun-polkadot-backend/channel/pallet/test"
 	chtest "github.com/perun-network/perun-polkadot-backend/channel/test"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/perun-network/perun-polkadot-backend/wallet"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	pchannel "perun.network/go-perun/channel"
+	pchtest "perun.network/go-perun/channel/test"
+	pwallettest "perun.network/go-perun/wallet/test"
+	pkgtest "polycry.pt/poly-go/test"
 )
 
 func TestDepositor_NotDeposited(t *testing.T) {
@@ -60,3 +71,117 @@ func TestDepositor_DepositMultiple(t *testing.T) {
 	finalBals := test.Multiply(2, dSetup.FinalBals...)
 	s.AssertDeposits(dSetup.FIDs, finalBals)
 }
+
+// TestDepositor_Sponsored checks that a deposit is paid by the payer and
+// credited to the participant of the funding ID.
+func TestDepositor_Sponsored(t *testing.T) {
+	s := test.NewSetup(t)
+	params, state := s.NewRandomParamAndState()
+	dSetup := chtest.NewDepositSetup(params, state)
+	// Charlie, who is not a participant of the channel, pays the deposit of
+	// Alice.
+	req := pallet.NewDepositReq(dSetup.FinalBals[0], s.Charlie.Acc, dSetup.FIDs[0])
+
+	alice := wallet.AsAddr(s.Alice.Acc.Address()).AccountID()
+	bob := wallet.AsAddr(s.Bob.Acc.Address()).AccountID()
+	charlie := wallet.AsAddr(s.Charlie.Acc.Address()).AccountID()
+	deltas := map[types.AccountID]*big.Int{
+		alice:   big.NewInt(0),
+		bob:     big.NewInt(0),
+		charlie: dSetup.FinalBals[0],
+	}
+	s.AssertBalanceChanges(deltas, new(big.Int).SetUint64(test.DefaultExtFee), func() {
+		require.NoError(t, s.Deps[0].Deposit(s.NewCtx(), req))
+	})
+	s.AssertDeposit(dSetup.FIDs[0], dSetup.FinalBals[0])
+	s.AssertNoDeposit(dSetup.FIDs[1])
+}
+
+// TestDepositor_DeprecatedAccount checks that the deprecated Account field
+// is used as payer if no Payer is set.
+func TestDepositor_DeprecatedAccount(t *testing.T) {
+	s := test.NewSetup(t)
+	params, state := s.NewRandomParamAndState()
+	dSetup := chtest.NewDepositSetup(params, state)
+	req := &pallet.DepositReq{
+		Balance:   dSetup.FinalBals[0],
+		Account:   s.Charlie.Acc,
+		FundingID: dSetup.FIDs[0],
+	}
+
+	charlie := wallet.AsAddr(s.Charlie.Acc.Address()).AccountID()
+	deltas := map[types.AccountID]*big.Int{charlie: dSetup.FinalBals[0]}
+	s.AssertBalanceChanges(deltas, new(big.Int).SetUint64(test.DefaultExtFee), func() {
+		require.NoError(t, s.Deps[0].Deposit(s.NewCtx(), req))
+	})
+	s.AssertDeposit(dSetup.FIDs[0], dSetup.FinalBals[0])
+}
+
+// TestDepositor_InsufficientFunds checks that a deposit that the payer cannot
+// afford is rejected before it is sent.
+func TestDepositor_InsufficientFunds(t *testing.T) {
+	s := test.NewSetup(t)
+	params, state := s.NewRandomParamAndState()
+	dSetup := chtest.NewDepositSetup(params, state)
+	alice := wallet.AsAddr(s.Alice.Acc.Address()).AccountID()
+	info, err := s.API.AccountInfo(alice)
+	require.NoError(t, err)
+
+	// Alice cannot pay the fee if she deposits all her funds.
+	req := pallet.NewDepositReq(info.Free.Int, s.Alice.Acc, dSetup.FIDs[0])
+	s.AssertBalanceChanges(map[types.AccountID]*big.Int{alice: big.NewInt(0)}, big.NewInt(0), func() {
+		err = s.Deps[0].Deposit(s.NewCtx(), req)
+	})
+	require.ErrorIs(t, err, substrate.ErrInsufficientFunds)
+	s.AssertNoDeposit(dSetup.FIDs[0])
+}
+
+// TestNewDepositReqFromPerun checks that deposit requests use the share of
+// the funding agreement and that malformed agreements are rejected.
+func TestNewDepositReqFromPerun(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	params, state := pchtest.NewRandomParamsAndState(rng, chtest.DefaultRandomOpts())
+	acc := pwallettest.NewRandomAccount(rng)
+
+	tests := []struct {
+		name      string
+		agreement func() pchannel.Balances
+		valid     bool
+	}{
+		{"initial balances", func() pchannel.Balances { return state.Balances.Clone() }, true},
+		{"one-sided", func() pchannel.Balances {
+			return pchannel.Balances{{state.Balances.Sum()[0], big.NewInt(0)}}
+		}, true},
+		{"no assets", func() pchannel.Balances { return pchannel.Balances{} }, false},
+		{"two assets", func() pchannel.Balances {
+			return append(state.Balances.Clone(), state.Balances.Clone()[0])
+		}, false},
+		{"missing participant", func() pchannel.Balances {
+			return pchannel.Balances{{state.Balances[0][0]}}
+		}, false},
+		{"negative balance", func() pchannel.Balances {
+			return pchannel.Balances{{big.NewInt(-1), state.Balances[0][1]}}
+		}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for idx := range params.Parts {
+				agreement := tc.agreement()
+				req := pchannel.NewFundingReq(params, state, pchannel.Index(idx), agreement)
+				dReq, err := pallet.NewDepositReqFromPerun(req, acc)
+				if !tc.valid {
+					assert.ErrorIs(t, err, pallet.ErrFundingReqIncompatible)
+					continue
+				}
+				require.NoError(t, err)
+				assert.Equal(t, agreement[0][idx], dReq.Balance)
+				fReq, err := channel.MakeFundingReq(req)
+				require.NoError(t, err)
+				fid, err := fReq.ID()
+				require.NoError(t, err)
+				assert.Equal(t, fid, dReq.FundingID)
+			}
+		})
+	}
+}
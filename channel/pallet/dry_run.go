@@ -0,0 +1,76 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
+
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+)
+
+// WithDryRun makes the Adjudicator execute every signed Extrinsic on top of
+// the latest block before submitting it. An Extrinsic whose call would fail
+// is not submitted and its decoded error is returned instead.
+// Extrinsics that wait for other pending Extrinsics of the account, like
+// resubmissions with the next free nonce of the pool, can not be executed
+// and are submitted unchecked.
+// The dry run uses system_dryRun, which is an unsafe RPC method. Public
+// nodes usually do not offer it, the node must run with
+// --rpc-methods=Unsafe. Disabled by default.
+func WithDryRun() AdjudicatorOpt {
+	return func(a *Adjudicator) {
+		a.dryRun = true
+	}
+}
+
+// DryRunCall executes a signed Extrinsic on top of the latest block without
+// submitting it. Returns the decoded error of its call, see DecodeError, or
+// an error that wraps substrate.ErrTxInvalid if it would not be included.
+func (p *Pallet) DryRunCall(ext *types.Extrinsic) error {
+	dErr, err := p.DryRun(ext)
+	if err != nil {
+		return err
+	}
+	if dErr != nil {
+		return p.DecodeError(*dErr)
+	}
+	return nil
+}
+
+// checkCall dry runs an Extrinsic if the Adjudicator is configured to do so.
+// Operations with an unfinished journal entry are not checked since they can
+// already be included and are then resumed instead of being submitted.
+func (a *Adjudicator) checkCall(op OpKey, ext *types.Extrinsic) error {
+	if !a.dryRun {
+		return nil
+	}
+	if a.journal != nil {
+		last, found, err := a.journal.Last(op)
+		if err != nil {
+			return err
+		}
+		if found && last.Pending() {
+			return nil
+		}
+	}
+	if err := a.pallet.DryRunCall(ext); errors.Is(err, substrate.ErrTxFuture) {
+		a.Log().WithField("op", op).Debug("Extrinsic waits for pending extrinsics, skipping dry run")
+	} else if err != nil {
+		a.Log().WithField("op", op).WithError(err).Warn("Dry run failed, not submitting extrinsic")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet"
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet/test"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/perun-network/perun-polkadot-backend/wallet"
+)
+
+// TestAdjudicator_DryRun checks that a call that would fail is not
+// submitted.
+func TestAdjudicator_DryRun(t *testing.T) {
+	s := test.NewSetup(t)
+	adj := pallet.NewAdjudicator(s.Alice.Acc, s.Pallet, s.API, test.PastBlocks, pallet.WithDryRun())
+	alice := wallet.AsAddr(s.Alice.Acc.Address()).AccountID()
+	req := newUnfundedFinalReq(s)
+
+	nonce, err := s.Pallet.AccountNonce(alice)
+	require.NoError(t, err)
+	err = adj.Withdraw(s.NewCtx(), req, nil)
+	assert.ErrorIs(t, err, substrate.ErrCallFailed)
+	var pErr *pallet.PalletError
+	assert.ErrorAs(t, err, &pErr)
+
+	// Nothing was submitted.
+	after, err := s.Pallet.AccountNonce(alice)
+	require.NoError(t, err)
+	assert.Equal(t, nonce, after)
+
+	// A valid call passes the dry run.
+	req, _, _ = newAdjReq(s, false)
+	require.NoError(t, adj.Register(s.NewCtx(), req, nil))
+}
@@ -0,0 +1,268 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"sync"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
+	"perun.network/go-perun/log"
+	pkgsync "polycry.pt/poly-go/sync"
+
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+)
+
+// ErrHubClosed the EventHub is closed.
+var ErrHubClosed = errors.New("event hub closed")
+
+type (
+	// EventHub multiplexes one event subscription to many EventSubs.
+	// The events of each block are decoded once and then forwarded to all
+	// subscribers whose predicate matches. Every subscriber has its own
+	// unbounded buffer, so a slow subscriber does not block the others.
+	//
+	// The hub retains the events of the last `history` blocks to serve
+	// subscriptions that look into the past. One change set of the System
+	// events is counted as one block since the events change every block.
+	EventHub struct {
+		*pkgsync.Closer
+		log.Embedding
+
+		source  *substrate.EventSource
+		meta    *types.Metadata
+		history types.BlockNumber
+
+		mtx    sync.Mutex
+		blocks [][]BlockEvent // Newest block last.
+		subs   map[*hubSub]struct{}
+		err    error
+	}
+
+	// hubSub is a subscriber of an EventHub.
+	hubSub struct {
+		sub    *EventSub
+		notify chan struct{}
+
+		mtx   sync.Mutex
+		queue []BlockEvent
+		done  bool
+		err   error
+	}
+)
+
+// NewEventHub returns a new EventHub for the pallet that retains the events
+// of the last `history` blocks. The hub must be closed when not needed
+// anymore.
+func NewEventHub(p *Pallet, history types.BlockNumber) (*EventHub, error) {
+	source, err := p.Pallet.Subscribe(history)
+	if err != nil {
+		return nil, err
+	}
+	h := &EventHub{
+		Closer:    new(pkgsync.Closer),
+		Embedding: log.MakeEmbedding(log.Default()),
+		source:    source,
+		meta:      p.meta,
+		history:   history,
+		subs:      make(map[*hubSub]struct{}),
+	}
+	h.OnCloseAlways(func() {
+		if err := source.Close(); err != nil && !pkgsync.IsAlreadyClosedError(err) {
+			h.Log().WithError(err).Error("Could not close EventSource.")
+		}
+		h.mtx.Lock()
+		defer h.mtx.Unlock()
+		for s := range h.subs {
+			s.finish(h.err)
+		}
+		h.subs = nil
+	})
+	go h.dispatch()
+	return h, nil
+}
+
+// History returns the number of past blocks that the hub retains.
+func (h *EventHub) History() types.BlockNumber {
+	return h.history
+}
+
+// Subscribe returns an EventSub that receives all events that match the
+// predicate, starting `pastBlocks` blocks in the past. `pastBlocks` must not
+// exceed the History of the hub.
+func (h *EventHub) Subscribe(f EventPredicate, pastBlocks types.BlockNumber) (*EventSub, error) {
+	if pastBlocks > h.history {
+		return nil, errors.Errorf("hub retains %d past blocks but %d were requested", h.history, pastBlocks)
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.subs == nil {
+		if h.err != nil {
+			return nil, errors.WithMessage(h.err, ErrHubClosed.Error())
+		}
+		return nil, ErrHubClosed
+	}
+
+	s := &hubSub{sub: newEventSub(f), notify: make(chan struct{}, 1)}
+	start := len(h.blocks) - int(pastBlocks) - 1
+	if start < 0 {
+		start = 0
+	}
+	for _, events := range h.blocks[start:] {
+		s.push(events)
+	}
+	h.subs[s] = struct{}{}
+	s.sub.OnClose(func() { h.remove(s) })
+	go s.pump()
+	return s.sub, nil
+}
+
+// remove removes a subscriber from the hub.
+func (h *EventHub) remove(s *hubSub) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	delete(h.subs, s)
+}
+
+// dispatch decodes the events of the source and forwards them to all
+// subscribers.
+func (h *EventHub) dispatch() {
+	h.Log().Debug("EventHub started")
+	defer h.Log().Debug("EventHub stopped")
+
+	for {
+		select {
+		case set := <-h.source.Events():
+			events, err := decodeBlockEvents(set, h.meta)
+			if err != nil {
+				h.fail(errors.WithMessage(err, "decoding event records"))
+				return
+			}
+			h.publish(events)
+		case err := <-h.source.Err():
+			h.fail(err)
+			return
+		case <-h.Closed():
+			return
+		}
+	}
+}
+
+// publish retains the events of a block and forwards them to all
+// subscribers.
+func (h *EventHub) publish(events []BlockEvent) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	h.blocks = append(h.blocks, events)
+	if len(h.blocks) > int(h.history)+1 {
+		h.blocks[0] = nil
+		h.blocks = h.blocks[1:]
+	}
+	for s := range h.subs {
+		s.push(events)
+	}
+}
+
+// fail closes the hub and all its subscribers with an error.
+func (h *EventHub) fail(err error) {
+	if err != nil {
+		h.Log().WithError(err).Error("EventHub failed")
+		h.mtx.Lock()
+		h.err = err
+		h.mtx.Unlock()
+	}
+	if err := h.Close(); err != nil && !pkgsync.IsAlreadyClosedError(err) {
+		h.Log().WithError(err).Error("Could not close EventHub.")
+	}
+}
+
+// push queues the events that match the predicate of the subscriber.
+func (s *hubSub) push(events []BlockEvent) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	matched := false
+	for _, e := range events {
+		if s.sub.p(e.Event) {
+			s.queue = append(s.queue, e)
+			matched = true
+		}
+	}
+	if matched {
+		s.signal()
+	}
+}
+
+// finish stops the subscriber after it forwarded all queued events.
+func (s *hubSub) finish(err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.done, s.err = true, err
+	s.signal()
+}
+
+// signal wakes up the pump. Must be called with the lock held.
+func (s *hubSub) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take returns and clears the queued events.
+func (s *hubSub) take() (events []BlockEvent, done bool, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	events, s.queue = s.queue, nil
+	return events, s.done, s.err
+}
+
+// pump forwards the queued events to the EventSub until it is closed or the
+// hub finished it.
+func (s *hubSub) pump() {
+	sub := s.sub
+	var err error
+	defer func() {
+		sub.errChan <- err
+		close(sub.errChan)
+		sub.Close()
+	}()
+
+	for {
+		events, done, _err := s.take()
+		for _, e := range events {
+			select {
+			case sub.sink <- e:
+			case <-sub.Closed():
+				return
+			}
+		}
+		if done {
+			err = _err
+			return
+		}
+		if len(events) > 0 {
+			continue
+		}
+		select {
+		case <-s.notify:
+		case <-sub.Closed():
+			return
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ctxtest "polycry.pt/poly-go/context/test"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet"
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet/test"
+	chtest "github.com/perun-network/perun-polkadot-backend/channel/test"
+)
+
+func TestEventHub(t *testing.T) {
+	s := test.NewSetup(t)
+	hub, err := pallet.NewEventHub(s.Pallet, test.PastBlocks)
+	require.NoError(t, err)
+	p := s.Pallet.WithEventHub(hub)
+	params, state := s.NewRandomParamAndState()
+	dSetup := chtest.NewDepositSetup(params, state)
+	ctx := s.NewCtx()
+
+	isDeposited := func(i int) pallet.EventPredicate {
+		return func(e channel.PerunEvent) bool {
+			d, ok := e.(*channel.DepositedEvent)
+			return ok && d.Fid == dSetup.FIDs[i]
+		}
+	}
+	subAlice, err := p.Subscribe(isDeposited(0), 0)
+	require.NoError(t, err)
+	subBob, err := p.Subscribe(isDeposited(1), 0)
+	require.NoError(t, err)
+	// Closing one subscription does not affect the others.
+	closed, err := p.Subscribe(channel.EventIsDeposited, 0)
+	require.NoError(t, err)
+	require.NoError(t, closed.Close())
+
+	require.NoError(t, test.DepositAll(ctx, s.Deps, dSetup.DReqs))
+	for i, sub := range []*pallet.EventSub{subAlice, subBob} {
+		event := (<-sub.Events()).(*channel.DepositedEvent)
+		assert.Equal(t, dSetup.FIDs[i], event.Fid)
+	}
+
+	// A late subscription receives the past events.
+	late, err := p.Subscribe(isDeposited(0), test.PastBlocks)
+	require.NoError(t, err)
+	ctxtest.AssertTerminates(t, s.BlockTime, func() {
+		event := (<-late.Events()).(*channel.DepositedEvent)
+		assert.Equal(t, dSetup.FIDs[0], event.Fid)
+	})
+
+	// Closing the hub closes all subscriptions without error.
+	require.NoError(t, hub.Close())
+	for _, sub := range []*pallet.EventSub{subAlice, subBob, late} {
+		ctxtest.AssertTerminatesQuickly(t, func() {
+			assert.NoError(t, <-sub.Err())
+		})
+	}
+	_, err = p.Subscribe(channel.EventIsDeposited, 0)
+	assert.ErrorIs(t, err, pallet.ErrHubClosed)
+}
@@ -15,6 +15,8 @@
 package pallet
 
 import (
+	"sync"
+
 	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
 	"perun.network/go-perun/log"
 	pkgsync "polycry.pt/poly-go/sync"
@@ -31,18 +33,33 @@ type (
 
 		source  *substrate.EventSource
 		p       EventPredicate
-		sink    chan channel.PerunEvent
+		sink    chan BlockEvent
 		errChan chan error
+
+		events     chan channel.PerunEvent
+		eventsOnce sync.Once
 	}
 
 	// EventPredicate can be used to filter events.
 	EventPredicate func(channel.PerunEvent) bool
+
+	// BlockEvent is a Perun event together with its position in the chain.
+	BlockEvent struct {
+		// Block is the hash of the block that emitted the event.
+		Block types.Hash
+		// Index is the position of the event among the Perun events of the
+		// block.
+		Index int
+		// Event is the Perun event.
+		Event channel.PerunEvent
+	}
 )
 
 // NewEventSub creates a new EventSub.
 // Takes ownership of `source` and closes it when done.
 func NewEventSub(source *substrate.EventSource, meta *types.Metadata, p EventPredicate) *EventSub {
-	sub := &EventSub{Closer: new(pkgsync.Closer), Embedding: log.MakeEmbedding(log.Default()), source: source, sink: make(chan channel.PerunEvent, substrate.ChanBuffSize), p: p, errChan: make(chan error, 1)}
+	sub := newEventSub(p)
+	sub.source = source
 	sub.OnClose(func() {
 		if err := source.Close(); err != nil {
 			sub.Log().WithError(err).Error("Could not close Closer.")
@@ -75,24 +92,68 @@ func NewEventSub(source *substrate.EventSource, meta *types.Metadata, p EventPre
 	return sub
 }
 
+// newEventSub returns an EventSub without a source.
+func newEventSub(p EventPredicate) *EventSub {
+	return &EventSub{Closer: new(pkgsync.Closer), Embedding: log.MakeEmbedding(log.Default()), sink: make(chan BlockEvent, substrate.ChanBuffSize), p: p, errChan: make(chan error, 1)}
+}
+
 // decodeEventRecords decodes all Perun events from the passed event records.
-func (p *EventSub) decodeEventRecords(rawRecord types.EventRecordsRaw, meta *types.Metadata) error {
-	record := channel.EventRecords{}
-	if err := rawRecord.DecodeEventRecords(meta, &record); err != nil {
+func (p *EventSub) decodeEventRecords(set substrate.BlockRecords, meta *types.Metadata) error {
+	events, err := decodeBlockEvents(set, meta)
+	if err != nil {
 		return err
 	}
-	for _, e := range record.Events() {
-		if p.p(e) {
+	for _, e := range events {
+		if p.p(e.Event) {
 			p.sink <- e
 		}
 	}
 	return nil
 }
 
+// decodeBlockEvents decodes all Perun events of a block in chain order.
+func decodeBlockEvents(set substrate.BlockRecords, meta *types.Metadata) ([]BlockEvent, error) {
+	records, err := channel.DecodeEventRecords(set.Records, meta)
+	if err != nil {
+		return nil, err
+	}
+	events := records.Events()
+	ret := make([]BlockEvent, len(events))
+	for i, e := range events {
+		ret[i] = BlockEvent{Block: set.Block, Index: i, Event: e}
+	}
+	return ret, nil
+}
+
+// BlockEvents returns the channel that contains all Perun events together
+// with their position in the chain. Will never be closed.
+// Use either BlockEvents or Events but not both.
+func (p *EventSub) BlockEvents() <-chan BlockEvent {
+	return p.sink
+}
+
 // Events returns the channel that contains all Perun events.
 // Will never be closed.
+// Use either BlockEvents or Events but not both.
 func (p *EventSub) Events() <-chan channel.PerunEvent {
-	return p.sink
+	p.eventsOnce.Do(func() {
+		p.events = make(chan channel.PerunEvent, substrate.ChanBuffSize)
+		go func() {
+			for {
+				select {
+				case e := <-p.sink:
+					select {
+					case p.events <- e.Event:
+					case <-p.Closed():
+						return
+					}
+				case <-p.Closed():
+					return
+				}
+			}
+		}()
+	})
+	return p.events
 }
 
 // Err returns the error channel. Will be closed when the subscription is closed.
@@ -0,0 +1,28 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	pchannel "perun.network/go-perun/channel"
+	pwallet "perun.network/go-perun/wallet"
+)
+
+// PalletErrors exports the mapped error variants for the tests.
+var PalletErrors = palletErrors
+
+// ReceiverOf exports receiverOf for the tests.
+func (a *Adjudicator) ReceiverOf(cid pchannel.ID) pwallet.Address {
+	return a.receiverOf(cid)
+}
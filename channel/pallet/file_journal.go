@@ -0,0 +1,134 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FileJournal is a Journal that appends its entries as JSON lines to a file.
+// All entries are kept in memory.
+type FileJournal struct {
+	mtx  sync.Mutex
+	file *os.File
+	last map[OpKey]JournalEntry
+}
+
+// NewFileJournal opens or creates the journal file at `path` and reads its
+// entries. An incomplete last line, which is left by a crash while writing,
+// is removed.
+func NewFileJournal(path string) (*FileJournal, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.WithMessage(err, "reading journal")
+	}
+	last, valid, err := parseJournal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, errors.WithMessage(err, "opening journal")
+	}
+	if err := file.Truncate(valid); err != nil {
+		file.Close() // nolint: errcheck
+		return nil, errors.WithMessage(err, "truncating journal")
+	}
+	if _, err := file.Seek(valid, 0); err != nil {
+		file.Close() // nolint: errcheck
+		return nil, errors.WithMessage(err, "seeking journal")
+	}
+	return &FileJournal{file: file, last: last}, nil
+}
+
+// parseJournal parses the lines of a journal file. Returns the latest entry
+// of each operation and the length of the valid prefix of `data`.
+func parseJournal(data []byte) (map[OpKey]JournalEntry, int64, error) {
+	last := make(map[OpKey]JournalEntry)
+	var valid int64
+	for len(data) != 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			// Incomplete last line.
+			break
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(data[:end], &entry); err != nil {
+			return nil, 0, errors.WithMessagef(err, "parsing journal at offset %d", valid)
+		}
+		last[entry.Op] = entry
+		valid += int64(end + 1)
+		data = data[end+1:]
+	}
+	return last, valid, nil
+}
+
+// Record appends an entry to the journal file and syncs it to disk.
+func (j *FileJournal) Record(entry JournalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return errors.WithMessage(err, "writing journal")
+	}
+	if err := j.file.Sync(); err != nil {
+		return errors.WithMessage(err, "syncing journal")
+	}
+	j.last[entry.Op] = entry
+	return nil
+}
+
+// Last returns the latest entry of an operation.
+func (j *FileJournal) Last(op OpKey) (JournalEntry, bool, error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	entry, found := j.last[op]
+	return entry, found, nil
+}
+
+// Pending returns the latest entry of all pending operations, oldest first.
+func (j *FileJournal) Pending() ([]JournalEntry, error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	var ret []JournalEntry
+	for _, entry := range j.last {
+		if entry.Pending() {
+			ret = append(ret, entry)
+		}
+	}
+	sort.Slice(ret, func(a, b int) bool { return ret[a].Time.Before(ret[b].Time) })
+	return ret, nil
+}
+
+// Close closes the journal file.
+func (j *FileJournal) Close() error {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	return j.file.Close()
+}
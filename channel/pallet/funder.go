@@ -16,68 +16,263 @@ package pallet
 
 import (
 	"context"
+	"math/big"
 	"time"
 
 	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
 	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/pkg/errors"
 	pchannel "perun.network/go-perun/channel"
 	"perun.network/go-perun/log"
 	pwallet "perun.network/go-perun/wallet"
 )
 
 // Funder implements the Funder interface to fund channels.
+// The participant that is funded is defined by each funding request, the
+// Funder only holds the on-chain account that pays the deposits. One Funder
+// can therefore sponsor the deposits of arbitrary participants.
 type Funder struct {
 	log.Embedding
 
-	pallet *Pallet
-	acc    pwallet.Account
+	pallet  *Pallet
+	payer   pwallet.Account
+	storage substrate.StorageQueryer
 	// pastBlocks is the number of blocks to query into the past.
 	pastBlocks types.BlockNumber
+	// timeout limits how long to wait for the deposits of the peers.
+	timeout FundingTimeout
+	// first are the participants that must fund before the Funder deposits.
+	first []pchannel.Index
+	// observer is informed about the funding progress, can be nil.
+	observer FundingObserver
+	// journal records all deposits, can be nil.
+	journal Journal
 }
 
-// NewFunder returns a new Funder.
-func NewFunder(pallet *Pallet, acc pwallet.Account, pastBlocks types.BlockNumber) *Funder {
-	return &Funder{log.MakeEmbedding(log.Default()), pallet, acc, pastBlocks}
+// FunderOpt configures a Funder.
+type FunderOpt func(*Funder)
+
+// FundingResult describes what a Funder did to fund a channel.
+type FundingResult struct {
+	// Transferred is the amount that was deposited by the Funder. It is
+	// zero if the existing deposit already covered the share.
+	Transferred pchannel.Bal
+}
+
+// WithFundingTimeout configures how long the Funder waits for the deposits
+// of its peers. Defaults to ChallengeDurationFundingTimeout.
+func WithFundingTimeout(timeout FundingTimeout) FunderOpt {
+	return func(f *Funder) {
+		f.timeout = timeout
+	}
+}
+
+// WithEgoisticFunding configures the participants that must fund before the
+// Funder deposits. The Funder then waits for their deposits before it
+// deposits its own share, which ensures that no funds are locked in channels
+// that these participants abandon. Has no effect if the Funder funds for one
+// of these participants itself.
+func WithEgoisticFunding(first ...pchannel.Index) FunderOpt {
+	return func(f *Funder) {
+		f.first = append([]pchannel.Index(nil), first...)
+	}
+}
+
+// WithFunderJournal configures a Journal that records all deposits of the
+// Funder. See WithDepositorJournal.
+func WithFunderJournal(journal Journal) FunderOpt {
+	return func(f *Funder) {
+		f.journal = journal
+	}
+}
+
+// NewFunder returns a new Funder. The `payer` signs all deposit extrinsics
+// and pays the deposited amounts and the fees.
+func NewFunder(pallet *Pallet, payer pwallet.Account, storage substrate.StorageQueryer, pastBlocks types.BlockNumber, opts ...FunderOpt) *Funder {
+	ret := &Funder{log.MakeEmbedding(log.Default()), pallet, payer, storage, pastBlocks, ChallengeDurationFundingTimeout(storage), nil, nil, nil}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	return ret
 }
 
 // Fund funds a channel. Needed by the Funder interface.
+// See FundWithResult.
 func (f *Funder) Fund(ctx context.Context, req pchannel.FundingReq) error {
+	_, err := f.FundWithResult(ctx, req)
+	return err
+}
+
+// FundWithResult funds a channel and returns how much was transferred.
+// It only deposits the difference between the agreed share and the existing
+// deposit, which makes it safe to retry after a crash. The deposit is
+// skipped if the share is already covered.
+// The result is also returned if waiting for the peers failed.
+func (f *Funder) FundWithResult(ctx context.Context, req pchannel.FundingReq) (*FundingResult, error) {
 	// Listen for Deposited events.
 	sub, err := f.pallet.Subscribe(channel.EventIsDeposited, f.pastBlocks)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer sub.Close()
 
-	// Deposit our funds.
-	wReq, err := NewDepositReqFromPerun(&req, f.acc)
+	dep := NewDepositor(f.pallet, WithDepositorJournal(f.journal))
+	return f.fund(ctx, sub, req, dep.Deposit)
+}
+
+// ResumePending resumes the deposits that the journal of the Funder holds
+// as pending, which is the case if the process stopped while they were in
+// flight, and waits for them. Should be called once after a restart.
+// Deposits that fail are recorded as failed. Does nothing without a
+// journal, see WithFunderJournal.
+func (f *Funder) ResumePending(ctx context.Context) error {
+	return resumePending(ctx, f.pallet, f.journal, OpDeposit)
+}
+
+// depositFunc deposits funds as specified by the request.
+type depositFunc func(context.Context, *DepositReq) error
+
+// fund funds a channel with the Deposited events from `events` and deposits
+// with `deposit`. See FundWithResult.
+func (f *Funder) fund(ctx context.Context, events depositEvents, req pchannel.FundingReq, deposit depositFunc) (*FundingResult, error) {
+	wReq, err := NewDepositReqFromPerun(&req, f.payer)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if err := NewDepositor(f.pallet).Deposit(ctx, wReq); err != nil {
-		return err
+	fids, err := calcFids(req)
+	if err != nil {
+		return nil, err
+	}
+	var timeout pchannel.Timeout
+	res := &FundingResult{Transferred: new(big.Int)}
+	prog := newProgressTracker(f.observer, f.pallet, f.storage, req, fids)
+
+	// Wait for the participants that must fund first.
+	if first := f.fundsFirst(req.Idx, fids); len(first) != 0 {
+		if timeout, err = f.timeout(req); err != nil {
+			return res, err
+		}
+		prog.setTimeout(timeout)
+		f.Log().Debugf("Waiting for %d peers to fund first", len(first))
+		if err := f.waitForFundings(ctx, events, req, first, timeout, prog); err != nil {
+			return res, err
+		}
+	}
+
+	// Deposit the missing part of our funds. A journaled deposit that was
+	// included before a crash must count towards the existing deposit.
+	if err := reconcile(f.pallet, f.journal, OpKey{OpDeposit, wReq.FundingID}); err != nil {
+		return nil, err
+	}
+	missing, err := f.missingDeposit(wReq.FundingID, wReq.Balance)
+	if err != nil {
+		return nil, err
+	}
+	if missing.Sign() > 0 {
+		wReq.Balance = missing
+		if err := deposit(ctx, wReq); err != nil {
+			return nil, err
+		}
+		res.Transferred = missing
+	} else {
+		f.Log().WithField("fid", wReq.FundingID).Debug("Skipping deposit, share is already covered")
 	}
 
 	// Wait for all Deposited events.
-	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(req.Params.ChallengeDuration)*time.Second)
-	defer cancel()
-	return f.waitForFundings(timeoutCtx, sub, req)
+	if timeout == nil {
+		if timeout, err = f.timeout(req); err != nil {
+			return res, err
+		}
+		prog.setTimeout(timeout)
+	}
+	return res, f.waitForFundings(ctx, events, req, fids, timeout, prog)
 }
 
-// waitForFundings blocks until either; all fundings of the request were received or
-// the context was cancelled.
-func (f *Funder) waitForFundings(ctx context.Context, sub *EventSub, req pchannel.FundingReq) error {
-	// Calculate the funding IDs.
-	fids, err := calcFids(req)
+// fundsFirst returns the funding IDs of the participants that must fund
+// before participant `idx` deposits. Returns nil if `idx` itself must fund
+// first.
+func (f *Funder) fundsFirst(idx pchannel.Index, fids map[channel.FundingID]pchannel.Index) map[channel.FundingID]pchannel.Index {
+	first := make(map[pchannel.Index]bool, len(f.first))
+	for _, i := range f.first {
+		first[i] = true
+	}
+	if first[idx] {
+		return nil
+	}
+
+	ret := make(map[channel.FundingID]pchannel.Index)
+	for fid, i := range fids {
+		if first[i] {
+			ret[fid] = i
+		}
+	}
+	return ret
+}
+
+// missingDeposit returns the amount that needs to be deposited for the
+// funding ID to hold at least `need`. Can be zero but never negative.
+func (f *Funder) missingDeposit(fid channel.FundingID, need pchannel.Bal) (pchannel.Bal, error) {
+	have, err := f.deposit(fid)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	missing := new(big.Int).Sub(need, have)
+	if missing.Sign() < 0 {
+		missing.SetInt64(0)
+	}
+	return missing, nil
+}
+
+// deposit returns the current deposit of a funding ID. Returns 0 if there
+// is no deposit.
+func (f *Funder) deposit(fid channel.FundingID) (pchannel.Bal, error) {
+	dep, err := f.pallet.QueryDeposit(fid, f.storage, 0)
+	if errors.Is(err, ErrNoDeposit) {
+		return new(big.Int), nil
+	} else if err != nil {
+		return nil, err
+	}
+	return channel.MakePerunBalance(*dep), nil
+}
+
+// waitForFundings blocks until either; all fundings of the passed funding
+// IDs were received, the timeout elapsed or the context was cancelled.
+func (f *Funder) waitForFundings(ctx context.Context, sub depositEvents, req pchannel.FundingReq, _fids map[channel.FundingID]pchannel.Index, timeout pchannel.Timeout, prog *progressTracker) error {
+	// Copy the funding IDs since entries are removed while waiting.
+	fids := make(map[channel.FundingID]pchannel.Index, len(_fids))
+	for fid, idx := range _fids {
+		fids[fid] = idx
+	}
+	// Remove all peers that already funded. Their events could be too old
+	// for the subscription if Fund is retried.
+	for fid, idx := range fids {
+		have, err := f.deposit(fid)
+		if err != nil {
+			return err
+		}
+		prog.update(ctx, fid, have)
+		if have.Cmp(req.Agreement[0][idx]) >= 0 {
+			delete(fids, fid)
+		}
 	}
 	f.Log().Tracef("Waiting for funding from %d peers", len(fids))
+	since := time.Now()
+
+	timeoutCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	expired := make(chan struct{})
+	go func() {
+		if timeout.Wait(timeoutCtx) == nil {
+			close(expired)
+		}
+	}()
 
 	for len(fids) != 0 {
 		select {
 		case _event := <-sub.Events(): // never returns nil
 			event := _event.(*channel.DepositedEvent)
+			prog.update(ctx, event.Fid, channel.MakePerunBalance(event.Balance))
 			// Only consider final events.
 			if !event.Phase.IsApplyExtrinsic {
 				continue
@@ -95,37 +290,21 @@ func (f *Funder) waitForFundings(ctx context.Context, sub *EventSub, req pchanne
 			}
 		case err := <-sub.Err():
 			return err
+		case <-expired:
+			return makeTimeoutErr(fids, since)
 		case <-ctx.Done():
-			return makeTimeoutErr(fids)
+			return makeTimeoutErr(fids, since)
 		}
 	}
 	return nil
 }
 
-// makeTimeoutErr returns a FundingTimeoutError.
-func makeTimeoutErr(remains map[channel.FundingID]pchannel.Index) error {
-	indices := make([]pchannel.Index, 0, len(remains))
-	for _, idx := range remains {
-		indices = append(indices, idx)
-	}
-	return pchannel.NewFundingTimeoutError(
-		[]*pchannel.AssetFundingError{{
-			Asset:         channel.Asset.Index(),
-			TimedOutPeers: indices,
-		}},
-	)
-}
-
 // calcFids calculates all funding ids of the funding request.
 func calcFids(req pchannel.FundingReq) (map[channel.FundingID]pchannel.Index, error) {
 	ids := make(map[channel.FundingID]pchannel.Index)
 
 	for i, part := range req.Params.Parts {
-		_part, err := channel.MakeOffIdent(part)
-		if err != nil {
-			return nil, err
-		}
-		fid, err := channel.NewFunding(req.State.ID, _part).ID()
+		fid, err := calcFid(req.State.ID, part)
 		if err != nil {
 			return nil, err
 		}
@@ -134,3 +313,12 @@ func calcFids(req pchannel.FundingReq) (map[channel.FundingID]pchannel.Index, er
 
 	return ids, nil
 }
+
+// calcFid calculates the funding id of a participant in a channel.
+func calcFid(cid channel.ChannelID, part pwallet.Address) (channel.FundingID, error) {
+	_part, err := channel.MakeOffIdent(part)
+	if err != nil {
+		return channel.FundingID{}, err
+	}
+	return channel.NewFunding(cid, _part).ID()
+}
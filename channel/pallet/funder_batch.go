@@ -0,0 +1,242 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"context"
+	"sync"
+
+	pchannel "perun.network/go-perun/channel"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/perun-network/perun-polkadot-backend/wallet"
+)
+
+type (
+	// depositEvents is a source of Deposited events, like an EventSub.
+	depositEvents interface {
+		// Events returns the channel that contains the events.
+		Events() <-chan channel.PerunEvent
+		// Err returns the error channel of the source.
+		Err() <-chan error
+	}
+
+	// depositRouter routes the Deposited events of one EventSub to the
+	// fundings of multiple channels.
+	depositRouter struct {
+		sub    *EventSub
+		routes map[channel.FundingID][]*depositRoute
+	}
+
+	// depositRoute receives the Deposited events of one channel.
+	depositRoute struct {
+		events chan channel.PerunEvent
+		errs   chan error
+		done   chan struct{}
+	}
+
+	// nonceDepositor sends the deposits of one payer at once by signing
+	// them with consecutive nonces.
+	nonceDepositor struct {
+		*Depositor
+
+		mtx  sync.Mutex
+		next uint64
+	}
+)
+
+// FundAll funds multiple channels with one event subscription. All deposits
+// are sent at once and FundAll then waits for the fundings of all channels
+// concurrently. Returns the result and error of each request in the order of
+// `reqs`, see FundWithResult.
+// Checks that the payer can afford all deposits before anything is signed.
+func (f *Funder) FundAll(ctx context.Context, reqs []pchannel.FundingReq) ([]*FundingResult, []error) {
+	results := make([]*FundingResult, len(reqs))
+	errs := make([]error, len(reqs))
+	failAll := func(err error) ([]*FundingResult, []error) {
+		for i := range errs {
+			errs[i] = err
+		}
+		return results, errs
+	}
+
+	if err := f.checkFundAll(reqs); err != nil {
+		return failAll(err)
+	}
+	sub, err := f.pallet.Subscribe(channel.EventIsDeposited, f.pastBlocks)
+	if err != nil {
+		return failAll(err)
+	}
+	defer sub.Close()
+
+	router := newDepositRouter(sub)
+	routes := make([]*depositRoute, len(reqs))
+	for i, req := range reqs {
+		fids, err := calcFids(req)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		routes[i] = router.route(fids)
+	}
+	go router.run(ctx)
+
+	dep := &nonceDepositor{Depositor: NewDepositor(f.pallet, WithDepositorJournal(f.journal))}
+	var wg sync.WaitGroup
+	for i := range reqs {
+		if routes[i] == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer routes[i].close()
+			results[i], errs[i] = f.fund(ctx, routes[i], reqs[i], dep.Deposit)
+		}(i)
+	}
+	wg.Wait()
+	return results, errs
+}
+
+// checkFundAll checks that the payer can afford the missing deposits of all
+// requests.
+func (f *Funder) checkFundAll(reqs []pchannel.FundingReq) error {
+	amounts := make([]pchannel.Bal, 0, len(reqs))
+	fids := make([]channel.FundingID, 0, len(reqs))
+	for i := range reqs {
+		wReq, err := NewDepositReqFromPerun(&reqs[i], f.payer)
+		if err != nil {
+			// Reported by the funding of the request.
+			continue
+		}
+		missing, err := f.missingDeposit(wReq.FundingID, wReq.Balance)
+		if err != nil {
+			return err
+		}
+		if missing.Sign() > 0 {
+			amounts = append(amounts, missing)
+			fids = append(fids, wReq.FundingID)
+		}
+	}
+	return f.pallet.CheckDeposits(f.payer, amounts, fids)
+}
+
+// newDepositRouter returns a new depositRouter for the EventSub.
+func newDepositRouter(sub *EventSub) *depositRouter {
+	return &depositRouter{sub: sub, routes: make(map[channel.FundingID][]*depositRoute)}
+}
+
+// route returns a route that receives the events of the funding IDs.
+// Must be called before run.
+func (r *depositRouter) route(fids map[channel.FundingID]pchannel.Index) *depositRoute {
+	route := &depositRoute{
+		events: make(chan channel.PerunEvent, substrate.ChanBuffSize),
+		errs:   make(chan error, 1),
+		done:   make(chan struct{}),
+	}
+	for fid := range fids {
+		r.routes[fid] = append(r.routes[fid], route)
+	}
+	return route
+}
+
+// run forwards the events of the EventSub until it fails or the context is
+// cancelled. Events of unknown funding IDs are dropped.
+// Each event is forwarded to all routes of its funding ID.
+func (r *depositRouter) run(ctx context.Context) {
+	for {
+		select {
+		case _event := <-r.sub.Events():
+			event := _event.(*channel.DepositedEvent)
+			for _, route := range r.routes[event.Fid] {
+				select {
+				case route.events <- event:
+				case <-route.done:
+				case <-ctx.Done():
+					return
+				}
+			}
+		case err := <-r.sub.Err():
+			for _, routes := range r.routes {
+				for _, route := range routes {
+					select {
+					case route.errs <- err:
+					default:
+					}
+				}
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Events returns the Deposited events of the channel.
+func (r *depositRoute) Events() <-chan channel.PerunEvent {
+	return r.events
+}
+
+// Err returns the error of the underlying EventSub.
+func (r *depositRoute) Err() <-chan error {
+	return r.errs
+}
+
+// close stops the forwarding of events to the route.
+func (r *depositRoute) close() {
+	close(r.done)
+}
+
+// Deposit deposits funds as specified by the request. It only blocks other
+// deposits while the extrinsic is submitted, but not while waiting for its
+// finalization.
+func (d *nonceDepositor) Deposit(ctx context.Context, req *DepositReq) error {
+	wait, err := d.send(req)
+	if err != nil {
+		return err
+	}
+	return wait(ctx)
+}
+
+// send signs a deposit with the next free nonce of the payer and submits it.
+// The nonce is only reserved if the deposit was submitted and not resumed
+// from the journal.
+func (d *nonceDepositor) send(req *DepositReq) (waitFunc, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	payer := req.payer()
+	nonce, err := d.pallet.AccountNonce(wallet.AsAddr(payer.Address()).AccountID())
+	if err != nil {
+		return nil, err
+	}
+	if nonce < d.next {
+		nonce = d.next
+	}
+	ext, err := d.pallet.BuildDepositWithNonce(payer, req.Balance, req.FundingID, nonce)
+	if err != nil {
+		return nil, err
+	}
+	d.Log().WithField("fid", req.FundingID).WithField("nonce", nonce).Debugf("Depositing %v", req.Balance)
+	wait, sent, err := send(d.pallet, d.journal, OpKey{OpDeposit, req.FundingID}, ext)
+	if err != nil {
+		return nil, err
+	}
+	if sent {
+		d.next = nonce + 1
+	}
+	return wait, nil
+}
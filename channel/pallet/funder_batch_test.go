@@ -0,0 +1,160 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet_test
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pchannel "perun.network/go-perun/channel"
+
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet"
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet/test"
+	chtest "github.com/perun-network/perun-polkadot-backend/channel/test"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/perun-network/perun-polkadot-backend/wallet"
+)
+
+// benchChannels is the number of channels that are funded per iteration of
+// the funding benchmarks.
+const benchChannels = 8
+
+func TestFunder_FundAll(t *testing.T) {
+	s := test.NewSetup(t)
+	setups := newDepositSetups(s, 3)
+
+	results, errs := fundAllParallel(s.NewCtx(), s.Funders, setups)
+	for i, dSetup := range setups {
+		for p := range s.Funders {
+			require.NoError(t, errs[p][i])
+			assert.Equal(t, dSetup.FinalBals[p], results[p][i].Transferred)
+		}
+		s.AssertDeposits(dSetup.FIDs, dSetup.FinalBals)
+	}
+}
+
+// TestFunder_FundAllSponsored checks that one Funder can fund all
+// participants of multiple channels in one call.
+func TestFunder_FundAllSponsored(t *testing.T) {
+	s := test.NewSetup(t)
+	setups := newDepositSetups(s, 2)
+	var reqs []pchannel.FundingReq
+	for _, dSetup := range setups {
+		for _, req := range dSetup.FReqs {
+			reqs = append(reqs, *req)
+		}
+	}
+
+	_, errs := s.Funders[0].FundAll(s.NewCtx(), reqs)
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	for _, dSetup := range setups {
+		s.AssertDeposits(dSetup.FIDs, dSetup.FinalBals)
+	}
+}
+
+// TestFunder_FundAllInsufficientFunds checks that nothing is deposited if the
+// payer cannot afford all deposits.
+func TestFunder_FundAllInsufficientFunds(t *testing.T) {
+	s := test.NewSetup(t)
+	setups := newDepositSetups(s, 2)
+	alice := wallet.AsAddr(s.Alice.Acc.Address()).AccountID()
+	info, err := s.API.AccountInfo(alice)
+	require.NoError(t, err)
+	// The second channel needs all funds of Alice. The agreement is cloned
+	// since it shares its balances with the state of the setup.
+	expensive := *setups[1].FReqs[0]
+	expensive.Agreement = expensive.Agreement.Clone()
+	expensive.Agreement[0][0] = new(big.Int).Set(info.Free.Int)
+
+	reqs := []pchannel.FundingReq{*setups[0].FReqs[0], expensive}
+	_, errs := s.Funders[0].FundAll(s.NewCtx(), reqs)
+	for _, err := range errs {
+		require.ErrorIs(t, err, substrate.ErrInsufficientFunds)
+	}
+	s.AssertNoDeposit(setups[0].FIDs[0])
+	s.AssertNoDeposit(setups[1].FIDs[0])
+}
+
+// BenchmarkFunder_Fund funds channels one after the other.
+func BenchmarkFunder_Fund(b *testing.B) {
+	s := test.NewSetup(b)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		setups := newDepositSetups(s, benchChannels)
+		ctx := s.NewCtx()
+		b.StartTimer()
+
+		for _, dSetup := range setups {
+			require.NoError(b, test.FundAll(ctx, s.Funders, dSetup.FReqs))
+		}
+	}
+}
+
+// BenchmarkFunder_FundAll funds channels with one FundAll call per
+// participant.
+func BenchmarkFunder_FundAll(b *testing.B) {
+	s := test.NewSetup(b)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		setups := newDepositSetups(s, benchChannels)
+		ctx := s.NewCtx()
+		b.StartTimer()
+
+		_, errs := fundAllParallel(ctx, s.Funders, setups)
+		for _, errs := range errs {
+			for _, err := range errs {
+				require.NoError(b, err)
+			}
+		}
+	}
+}
+
+// newDepositSetups returns deposit setups for `n` random channels.
+func newDepositSetups(s *test.Setup, n int) []*chtest.DepositSetup {
+	setups := make([]*chtest.DepositSetup, n)
+	for i := range setups {
+		params, state := s.NewRandomParamAndState()
+		setups[i] = chtest.NewDepositSetup(params, state)
+	}
+	return setups
+}
+
+// fundAllParallel calls FundAll on every funder in parallel. Funder `p`
+// funds participant `p` of every channel. Returns the results and errors
+// indexed by funder and channel.
+func fundAllParallel(ctx context.Context, funders []*pallet.Funder, setups []*chtest.DepositSetup) ([][]*pallet.FundingResult, [][]error) {
+	results := make([][]*pallet.FundingResult, len(funders))
+	errs := make([][]error, len(funders))
+	var wg sync.WaitGroup
+	for p := range funders {
+		reqs := make([]pchannel.FundingReq, len(setups))
+		for i, dSetup := range setups {
+			reqs[i] = *dSetup.FReqs[p]
+		}
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			results[p], errs[p] = funders[p].FundAll(ctx, reqs)
+		}(p)
+	}
+	wg.Wait()
+	return results, errs
+}
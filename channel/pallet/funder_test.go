@@ -16,15 +16,26 @@ package pallet_test
 
 import (
 	"context"
+	"math/big"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/centrifuge/go-substrate-rpc-client/v3/rpc/state"
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	pchannel "perun.network/go-perun/channel"
+	ctxtest "polycry.pt/poly-go/context/test"
+	pkgerrors "polycry.pt/poly-go/errors"
 
 	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet"
 	"github.com/perun-network/perun-polkadot-backend/channel/pallet/test"
 	chtest "github.com/perun-network/perun-polkadot-backend/channel/test"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/perun-network/perun-polkadot-backend/wallet"
 )
 
 func TestFunder_Fund(t *testing.T) {
@@ -39,7 +50,7 @@ func TestFunder_Fund(t *testing.T) {
 	s.AssertDeposits(dSetup.FIDs, dSetup.FinalBals)
 }
 
-// TestFunder_FundMultiple checks that funding twice results in twice the balance.
+// TestFunder_FundMultiple checks that funding twice does not deposit twice.
 func TestFunder_FundMultiple(t *testing.T) {
 	s := test.NewSetup(t)
 	params, state := s.NewRandomParamAndState()
@@ -49,30 +60,293 @@ func TestFunder_FundMultiple(t *testing.T) {
 	err := test.FundAll(ctx, s.Funders, dSetup.FReqs)
 	require.NoError(t, err)
 	// fund again
-	err = test.FundAll(ctx, s.Funders, dSetup.FReqs)
+	res, err := s.Funders[0].FundWithResult(ctx, *dSetup.FReqs[0])
 	require.NoError(t, err)
+	assert.Zero(t, res.Transferred.Sign())
 
 	// Check the on-chain balance.
-	finalBals := test.Multiply(2, dSetup.FinalBals...)
-	s.AssertDeposits(dSetup.FIDs, finalBals)
+	s.AssertDeposits(dSetup.FIDs, dSetup.FinalBals)
 }
 
-func TestFunder_Timeout(t *testing.T) {
+// TestFunder_TopUp checks that the funder only deposits the missing amount if
+// a part of the share was already deposited.
+func TestFunder_TopUp(t *testing.T) {
+	s := test.NewSetup(t)
+	params, state := s.NewRandomParamAndState()
+	dSetup := chtest.NewDepositSetup(params, state, s.Alice.Acc, s.Bob.Acc)
+	ctx := s.NewCtx()
+
+	// Alice deposits a third of her share directly.
+	share := dSetup.FinalBals[0]
+	partial := new(big.Int).Div(share, big.NewInt(3))
+	dReq := pallet.NewDepositReq(partial, s.Alice.Acc, dSetup.FIDs[0])
+	require.NoError(t, s.Deps[0].Deposit(ctx, dReq))
+
+	g := pkgerrors.NewGatherer()
+	var res *pallet.FundingResult
+	g.Go(func() (err error) {
+		res, err = s.Funders[0].FundWithResult(ctx, *dSetup.FReqs[0])
+		return
+	})
+	g.Go(func() error { return s.Funders[1].Fund(ctx, *dSetup.FReqs[1]) })
+	require.NoError(t, g.Wait())
+
+	assert.Equal(t, new(big.Int).Sub(share, partial), res.Transferred)
+	s.AssertDeposits(dSetup.FIDs, dSetup.FinalBals)
+}
+
+// TestFunder_ZeroShare checks that no deposit is made for a zero share.
+func TestFunder_ZeroShare(t *testing.T) {
+	s := test.NewSetup(t)
+	params, state := s.NewRandomParamAndState()
+	state.Balances[0][0] = big.NewInt(0)
+	dSetup := chtest.NewDepositSetup(params, state)
+
+	g := pkgerrors.NewGatherer()
+	var res *pallet.FundingResult
+	ctx := s.NewCtx()
+	g.Go(func() (err error) {
+		res, err = s.Funders[0].FundWithResult(ctx, *dSetup.FReqs[0])
+		return
+	})
+	g.Go(func() error { return s.Funders[1].Fund(ctx, *dSetup.FReqs[1]) })
+	require.NoError(t, g.Wait())
+
+	assert.Zero(t, res.Transferred.Sign())
+	s.AssertNoDeposit(dSetup.FIDs[0])
+	s.AssertDeposit(dSetup.FIDs[1], dSetup.FinalBals[1])
+}
+
+// TestFunder_Agreement checks that the funders deposit the shares of the
+// funding agreement instead of the initial balances.
+func TestFunder_Agreement(t *testing.T) {
+	s := test.NewSetup(t)
+	params, state := s.NewRandomParamAndState()
+	// Alice funds the whole channel.
+	agreement := pchannel.Balances{{state.Balances.Sum()[0], big.NewInt(0)}}
+	reqs := []*pchannel.FundingReq{
+		pchannel.NewFundingReq(params, state, 0, agreement),
+		pchannel.NewFundingReq(params, state, 1, agreement),
+	}
+	dSetup := chtest.NewDepositSetup(params, state)
+
+	require.NoError(t, test.FundAll(s.NewCtx(), s.Funders, reqs))
+	s.AssertDeposit(dSetup.FIDs[0], agreement[0][0])
+	s.AssertNoDeposit(dSetup.FIDs[1])
+}
+
+// TestFunder_Sponsored checks that each funder pays the deposit of the
+// participant in its funding request and not of itself.
+func TestFunder_Sponsored(t *testing.T) {
+	s := test.NewSetup(t)
+	params, state := s.NewRandomParamAndState()
+	dSetup := chtest.NewDepositSetup(params, state)
+	ctx := s.NewCtx()
+	// Bob pays for Alice and Alice pays for Bob.
+	funders := []*pallet.Funder{
+		pallet.NewFunder(s.Pallet, s.Bob.Acc, s.API, test.PastBlocks),
+		pallet.NewFunder(s.Pallet, s.Alice.Acc, s.API, test.PastBlocks),
+	}
+
+	alice := wallet.AsAddr(s.Alice.Acc.Address()).AccountID()
+	bob := wallet.AsAddr(s.Bob.Acc.Address()).AccountID()
+	deltas := map[types.AccountID]*big.Int{
+		alice: dSetup.FinalBals[1],
+		bob:   dSetup.FinalBals[0],
+	}
+	s.AssertBalanceChanges(deltas, new(big.Int).SetUint64(test.DefaultExtFee), func() {
+		require.NoError(t, test.FundAll(ctx, funders, dSetup.FReqs))
+	})
+	s.AssertDeposits(dSetup.FIDs, dSetup.FinalBals)
+}
+
+// TestFunder_Observer checks that the observer receives the funding progress.
+func TestFunder_Observer(t *testing.T) {
+	s := test.NewSetup(t)
+	params, state := s.NewRandomParamAndState()
+	dSetup := chtest.NewDepositSetup(params, state)
+	var (
+		mtx      sync.Mutex
+		progress []pallet.FundingProgress
+	)
+	observer := func(p pallet.FundingProgress) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		progress = append(progress, p)
+	}
+	funders := []*pallet.Funder{
+		pallet.NewFunder(s.Pallet, s.Alice.Acc, s.API, test.PastBlocks, pallet.WithFundingObserver(observer)),
+		s.Funders[1],
+	}
+
+	require.NoError(t, test.FundAll(s.NewCtx(), funders, dSetup.FReqs))
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	require.NotEmpty(t, progress)
+	last := progress[len(progress)-1]
+	assert.Equal(t, state.ID, last.Channel)
+	assert.Equal(t, 2, last.Funded())
+	assert.Greater(t, last.Remaining, time.Duration(0))
+	for i, peer := range last.Peers {
+		assert.Equal(t, dSetup.FinalBals[i], peer.Required)
+		assert.Equal(t, dSetup.FinalBals[i], peer.Deposited)
+	}
+	// Deposits that were reported as final must be in a finalized block.
+	head, err := s.API.FinalizedHead()
+	require.NoError(t, err)
+	for _, p := range progress {
+		for i, peer := range p.Peers {
+			if !peer.Final || peer.Deposited.Sign() == 0 {
+				continue
+			}
+			dep, err := s.Pallet.QueryDepositAt(dSetup.FIDs[i], s.API, head)
+			require.NoError(t, err)
+			assert.True(t, channel.MakePerunBalance(*dep).Cmp(peer.Deposited) >= 0)
+		}
+	}
+}
+
+func TestFundingProgress_Funded(t *testing.T) {
+	p := pallet.FundingProgress{Peers: []pallet.PeerProgress{
+		{Deposited: big.NewInt(0), Required: big.NewInt(0)},
+		{Deposited: big.NewInt(5), Required: big.NewInt(10)},
+		{Deposited: big.NewInt(11), Required: big.NewInt(10)},
+	}}
+	assert.Equal(t, 2, p.Funded())
+	assert.False(t, p.Peers[1].Funded())
+}
+
+// TestFunder_Egoistic checks that an egoistic funder only deposits after the
+// configured peers funded.
+func TestFunder_Egoistic(t *testing.T) {
+	s := test.NewSetup(t)
+	params, state := s.NewRandomParamAndState()
+	dSetup := chtest.NewDepositSetup(params, state)
+	ctx := s.NewCtx()
+	alice := pallet.NewFunder(s.Pallet, s.Alice.Acc, s.API, test.PastBlocks, pallet.WithEgoisticFunding(1))
+
+	aliceErr := make(chan error, 1)
+	go func() { aliceErr <- alice.Fund(ctx, *dSetup.FReqs[0]) }()
+	// Alice must not deposit while Bob did not fund.
+	time.Sleep(5 * s.BlockTime)
+	s.AssertNoDeposit(dSetup.FIDs[0])
+
+	require.NoError(t, s.Funders[1].Fund(ctx, *dSetup.FReqs[1]))
+	require.NoError(t, <-aliceErr)
+	s.AssertDeposits(dSetup.FIDs, dSetup.FinalBals)
+}
+
+// TestFunder_EgoisticTimeout checks that an egoistic funder does not deposit
+// if the configured peers do not fund.
+func TestFunder_EgoisticTimeout(t *testing.T) {
 	s := test.NewSetup(t)
 	params, state := s.NewRandomParamAndState()
-	params.ChallengeDuration = 10
 	dSetup := chtest.NewDepositSetup(params, state)
+	alice := pallet.NewFunder(s.Pallet, s.Alice.Acc, s.API, test.PastBlocks,
+		pallet.WithEgoisticFunding(1),
+		pallet.WithFundingTimeout(pallet.BlockFundingTimeout(5, s.API)))
+
+	err := alice.Fund(s.NewCtx(), *dSetup.FReqs[0])
+	require.True(t, pchannel.IsFundingTimeoutError(err))
+	assert.Equal(t, makeTimeoutErr(1).Error(), errors.Cause(err).Error())
+	s.AssertNoDeposit(dSetup.FIDs[0])
+}
 
-	// Bob did not fund and times out.
-	wantErr := makeTimeoutErr(1)
-	// Only call Alice's funder.
-	ctx, cancel := context.WithTimeout(context.Background(), 10*s.BlockTime)
-	defer cancel()
-	gotErr := s.Funders[0].Fund(ctx, *dSetup.FReqs[0])
-	// Check that the funder returned the correct error.
-	assert.True(t, pchannel.IsFundingTimeoutError(gotErr))
-	// Use equality on the error strings since Errors.Is does not work.
-	assert.Equal(t, wantErr.Error(), gotErr.Error())
+func TestFunder_Timeout(t *testing.T) {
+	s := test.NewSetup(t)
+	timeouts := []struct {
+		name    string
+		timeout pallet.FundingTimeout
+	}{
+		{"ChallengeDuration", pallet.ChallengeDurationFundingTimeout(s.API)},
+		{"WallClock", pallet.WallClockFundingTimeout(5 * s.BlockTime)},
+		{"ChainTime", pallet.ChainTimeFundingTimeout(5*s.BlockTime, s.API)},
+		{"Block", pallet.BlockFundingTimeout(5, s.API)},
+	}
+
+	for _, tc := range timeouts {
+		t.Run(tc.name, func(t *testing.T) {
+			params, state := s.NewRandomParamAndState()
+			params.ChallengeDuration = 2
+			dSetup := chtest.NewDepositSetup(params, state)
+			funder := pallet.NewFunder(s.Pallet, s.Accs[0].Acc, s.API, test.PastBlocks, pallet.WithFundingTimeout(tc.timeout))
+
+			// Only call Alice's funder, Bob did not fund and times out.
+			ctx, cancel := context.WithTimeout(context.Background(), 20*s.BlockTime)
+			defer cancel()
+			gotErr := funder.Fund(ctx, *dSetup.FReqs[0])
+			// Check that the funder returned the correct error.
+			require.True(t, pchannel.IsFundingTimeoutError(gotErr))
+			assert.NoError(t, ctx.Err(), "funder must time out before the context")
+			var timeoutErr pallet.FundingTimeoutError
+			require.True(t, errors.As(gotErr, &timeoutErr))
+			assert.Equal(t, makeTimeoutErr(1).Error(), timeoutErr.FundingTimeoutError.Error())
+			assert.Greater(t, timeoutErr.Waited, time.Duration(0))
+		})
+	}
+}
+
+func TestFundingTimeoutError(t *testing.T) {
+	inner := pchannel.FundingTimeoutError{Errors: []*pchannel.AssetFundingError{{Asset: channel.Asset.Index(), TimedOutPeers: []pchannel.Index{1}}}}
+	err := error(pallet.FundingTimeoutError{
+		FundingTimeoutError: inner,
+		Waited:              1500 * time.Millisecond,
+	})
+
+	assert.True(t, pchannel.IsFundingTimeoutError(err))
+	assert.True(t, pchannel.IsFundingTimeoutError(errors.WithMessage(err, "funding")))
+	assert.Contains(t, err.Error(), inner.Error())
+	assert.Equal(t, inner.Error()+": waited 1.5s", err.Error())
+}
+
+func TestChallengeDurationFundingTimeout_Blocks(t *testing.T) {
+	mode := channel.Backend.TimeoutMode()
+	defer func() { require.NoError(t, channel.Backend.SetTimeoutMode(mode)) }()
+	require.NoError(t, channel.Backend.SetTimeoutMode(channel.BlockTimeout))
+
+	storage := blockStorage(10)
+	req := pchannel.FundingReq{Params: &pchannel.Params{ChallengeDuration: 5}}
+	timeout, err := pallet.ChallengeDurationFundingTimeout(storage)(req)
+	require.NoError(t, err)
+	blockTimeout, ok := timeout.(*substrate.BlockTimeout)
+	require.True(t, ok, "got %T", timeout)
+	remaining, err := blockTimeout.RemainingBlocks(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, types.BlockNumber(5), remaining)
+}
+
+// blockStorage is a StorageQueryer that returns a fixed block number for
+// every query.
+type blockStorage uint32
+
+func (n blockStorage) QueryOne(types.BlockNumber, ...types.StorageKey) (*types.KeyValueOption, error) {
+	data, err := types.EncodeToBytes(uint32(n))
+	return &types.KeyValueOption{HasStorageData: true, StorageData: data}, err
+}
+
+func (blockStorage) Subscribe(...types.StorageKey) (*state.StorageSubscription, error) {
+	return nil, errors.New("not supported")
+}
+
+func (blockStorage) BuildKey(string, string, ...[]byte) (types.StorageKey, error) {
+	return nil, nil
+}
+
+func TestWallClockFundingTimeout(t *testing.T) {
+	timeout, err := pallet.WallClockFundingTimeout(50 * time.Millisecond)(pchannel.FundingReq{})
+	require.NoError(t, err)
+	assert.False(t, timeout.IsElapsed(context.Background()))
+	ctxtest.AssertTerminates(t, time.Second, func() {
+		assert.NoError(t, timeout.Wait(context.Background()))
+	})
+	assert.True(t, timeout.IsElapsed(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	timeout, err = pallet.WallClockFundingTimeout(time.Hour)(pchannel.FundingReq{})
+	require.NoError(t, err)
+	assert.ErrorIs(t, timeout.Wait(ctx), context.Canceled)
 }
 
 func makeTimeoutErr(idx pchannel.Index) error {
@@ -0,0 +1,236 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
+	pchannel "perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+)
+
+type (
+	// FundingObserver is called by the Funder whenever the funding progress
+	// of a channel changes. It is called synchronously and must not block.
+	FundingObserver func(FundingProgress)
+
+	// FundingProgress is a snapshot of the funding of a channel.
+	FundingProgress struct {
+		// Channel is the channel that is funded.
+		Channel channel.ChannelID
+		// Peers contains the progress of each participant, indexed by the
+		// participant index.
+		Peers []PeerProgress
+		// Remaining is the time until the funding timeout elapses. It is only
+		// set for time-based FundingTimeouts.
+		Remaining time.Duration
+		// RemainingBlocks is the number of blocks until the funding timeout
+		// elapses. It is only set for BlockFundingTimeout.
+		RemainingBlocks types.BlockNumber
+	}
+
+	// PeerProgress is the funding progress of one participant.
+	PeerProgress struct {
+		// Deposited is the amount that the participant deposited so far.
+		Deposited pchannel.Bal
+		// Required is the amount that the participant needs to deposit.
+		Required pchannel.Bal
+		// Final is true if the deposit at the last finalized block is at
+		// least Deposited, so that it can no longer be reverted. It is only
+		// set if the storage of the Funder is a substrate.FinalityQueryer and
+		// re-checked whenever the progress is reported.
+		Final bool
+	}
+
+	// progressTracker tracks the funding progress of a channel and reports
+	// it to a FundingObserver.
+	progressTracker struct {
+		log.Embedding
+
+		obs    FundingObserver
+		pallet *Pallet
+		chain  substrate.FinalityQueryer // nil if finality is unknown
+		fids   map[channel.FundingID]pchannel.Index
+		// finalHead is the finalized head at which finalDeps were read.
+		finalHead types.Hash
+		finalDeps map[channel.FundingID]pchannel.Bal
+		timeout   pchannel.Timeout
+		progress  FundingProgress
+	}
+
+	// timeRemainer is a pchannel.Timeout that knows the time until it elapses.
+	timeRemainer interface {
+		Remaining(context.Context) (time.Duration, error)
+	}
+
+	// blockRemainer is a pchannel.Timeout that knows the number of blocks
+	// until it elapses.
+	blockRemainer interface {
+		RemainingBlocks(context.Context) (types.BlockNumber, error)
+	}
+)
+
+// WithFundingObserver configures an observer that is informed about the
+// funding progress of each channel that the Funder funds.
+func WithFundingObserver(obs FundingObserver) FunderOpt {
+	return func(f *Funder) {
+		f.observer = obs
+	}
+}
+
+// Funded returns the number of participants that deposited the required
+// amount.
+func (p FundingProgress) Funded() int {
+	var ret int
+	for _, peer := range p.Peers {
+		if peer.Funded() {
+			ret++
+		}
+	}
+	return ret
+}
+
+// Funded returns whether the participant deposited the required amount.
+func (p PeerProgress) Funded() bool {
+	return p.Deposited.Cmp(p.Required) >= 0
+}
+
+// Remaining returns the time until the wall-clock timeout elapses.
+// Returns zero if it already elapsed.
+func (t *wallTimeout) Remaining(context.Context) (time.Duration, error) {
+	if rem := time.Until(t.when); rem > 0 {
+		return rem, nil
+	}
+	return 0, nil
+}
+
+// newProgressTracker returns a new progressTracker or nil if `obs` is nil.
+// All methods of a nil progressTracker are no-ops. The finality of deposits
+// is only determined if `storage` is a substrate.FinalityQueryer.
+func newProgressTracker(obs FundingObserver, pallet *Pallet, storage substrate.StorageQueryer, req pchannel.FundingReq, fids map[channel.FundingID]pchannel.Index) *progressTracker {
+	if obs == nil {
+		return nil
+	}
+	chain, _ := storage.(substrate.FinalityQueryer)
+	ret := &progressTracker{
+		Embedding: log.MakeEmbedding(log.Default()),
+		obs:       obs,
+		pallet:    pallet,
+		chain:     chain,
+		fids:      fids,
+		progress: FundingProgress{
+			Channel: req.State.ID,
+			Peers:   make([]PeerProgress, len(req.Params.Parts)),
+		},
+	}
+	for i := range ret.progress.Peers {
+		ret.progress.Peers[i] = PeerProgress{
+			Deposited: new(big.Int),
+			Required:  new(big.Int).Set(req.Agreement[0][i]),
+		}
+	}
+	return ret
+}
+
+// setTimeout sets the timeout whose remaining time is reported.
+func (t *progressTracker) setTimeout(timeout pchannel.Timeout) {
+	if t == nil {
+		return
+	}
+	t.timeout = timeout
+}
+
+// update records the deposit of a funding ID and notifies the observer.
+// Deposits of unknown funding IDs are ignored.
+func (t *progressTracker) update(ctx context.Context, fid channel.FundingID, deposited pchannel.Bal) {
+	if t == nil {
+		return
+	}
+	idx, ok := t.fids[fid]
+	if !ok {
+		return
+	}
+	t.progress.Peers[idx].Deposited = new(big.Int).Set(deposited)
+	t.updateFinal()
+	t.updateRemaining(ctx)
+	t.obs(t.snapshot())
+}
+
+// updateFinal sets the Final flags of all peers. The deposits at the last
+// finalized block are only read again when the finalized head changed.
+func (t *progressTracker) updateFinal() {
+	if t.chain == nil {
+		return
+	}
+	head, err := t.chain.FinalizedHead()
+	if err != nil {
+		t.Log().WithError(err).Warn("Could not determine the finalized head")
+		return
+	}
+	if t.finalDeps == nil || head != t.finalHead {
+		deps := make(map[channel.FundingID]pchannel.Bal, len(t.fids))
+		for fid := range t.fids {
+			dep, err := t.pallet.QueryDepositAt(fid, t.chain, head)
+			if errors.Is(err, ErrNoDeposit) {
+				deps[fid] = new(big.Int)
+			} else if err != nil {
+				t.Log().WithError(err).WithField("fid", fid).Warn("Could not read the finalized deposit")
+				return
+			} else {
+				deps[fid] = channel.MakePerunBalance(*dep)
+			}
+		}
+		t.finalHead, t.finalDeps = head, deps
+	}
+	for fid, idx := range t.fids {
+		peer := &t.progress.Peers[idx]
+		peer.Final = t.finalDeps[fid].Cmp(peer.Deposited) >= 0
+	}
+}
+
+// updateRemaining updates the remaining time or blocks of the timeout.
+func (t *progressTracker) updateRemaining(ctx context.Context) {
+	var err error
+	switch timeout := t.timeout.(type) {
+	case timeRemainer:
+		t.progress.Remaining, err = timeout.Remaining(ctx)
+	case blockRemainer:
+		t.progress.RemainingBlocks, err = timeout.RemainingBlocks(ctx)
+	}
+	if err != nil {
+		t.Log().WithError(err).Warn("Could not determine the remaining funding time")
+	}
+}
+
+// snapshot returns a deep copy of the progress.
+func (t *progressTracker) snapshot() FundingProgress {
+	ret := t.progress
+	ret.Peers = make([]PeerProgress, len(t.progress.Peers))
+	for i, peer := range t.progress.Peers {
+		ret.Peers[i] = PeerProgress{
+			Deposited: new(big.Int).Set(peer.Deposited),
+			Required:  new(big.Int).Set(peer.Required),
+			Final:     peer.Final,
+		}
+	}
+	return ret
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	pchannel "perun.network/go-perun/channel"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+)
+
+type (
+	// FundingTimeout creates the timeout that limits how long a Funder waits
+	// for the deposits of its peers. It is called once per funding request
+	// when the Funder starts waiting.
+	FundingTimeout func(req pchannel.FundingReq) (pchannel.Timeout, error)
+
+	// FundingTimeoutError is returned by the Funder if some peers did not
+	// fund in time. It wraps a pchannel.FundingTimeoutError such that
+	// pchannel.IsFundingTimeoutError still recognizes it.
+	FundingTimeoutError struct {
+		pchannel.FundingTimeoutError
+
+		// Waited is the wall-clock time that the Funder waited for the
+		// deposits. The Funder waits for all peers at once, so it is the same
+		// for every timed out peer.
+		Waited time.Duration
+	}
+
+	// wallTimeout is a pchannel.Timeout that expires at a wall-clock time.
+	wallTimeout struct {
+		when time.Time
+	}
+)
+
+// ChallengeDurationFundingTimeout waits for the challenge duration of the
+// channel. It is interpreted as wall-clock seconds or, if the TimeoutMode of
+// the Backend is BlockTimeout, as number of blocks. This is the default of
+// the Funder.
+func ChallengeDurationFundingTimeout(storage substrate.StorageQueryer) FundingTimeout {
+	return func(req pchannel.FundingReq) (pchannel.Timeout, error) {
+		if channel.Backend.TimeoutMode() == channel.BlockTimeout {
+			blocks := channel.MakeBlockNumber(req.Params.ChallengeDuration)
+			return BlockFundingTimeout(blocks, storage)(req)
+		}
+		d := channel.MakeDuration(req.Params.ChallengeDuration)
+		return &wallTimeout{time.Now().Add(d)}, nil
+	}
+}
+
+// WallClockFundingTimeout waits for a fixed wall-clock duration.
+func WallClockFundingTimeout(d time.Duration) FundingTimeout {
+	return func(pchannel.FundingReq) (pchannel.Timeout, error) {
+		return &wallTimeout{time.Now().Add(d)}, nil
+	}
+}
+
+// ChainTimeFundingTimeout waits until the chain time advanced by d.
+// The chain time is read from pallet Timestamp.
+func ChainTimeFundingTimeout(d time.Duration, storage substrate.StorageQueryer) FundingTimeout {
+	return func(pchannel.FundingReq) (pchannel.Timeout, error) {
+		now, err := substrate.ChainTime(storage)
+		if err != nil {
+			return nil, err
+		}
+		return substrate.NewTimeout(storage, now.Add(d), substrate.DefaultTimeoutPollInterval), nil
+	}
+}
+
+// BlockFundingTimeout waits until the chain produced `blocks` more blocks.
+func BlockFundingTimeout(blocks types.BlockNumber, storage substrate.StorageQueryer) FundingTimeout {
+	return func(pchannel.FundingReq) (pchannel.Timeout, error) {
+		now, err := substrate.ChainBlockNumber(storage)
+		if err != nil {
+			return nil, err
+		}
+		return substrate.NewBlockTimeout(storage, now+blocks, substrate.DefaultTimeoutPollInterval), nil
+	}
+}
+
+// IsElapsed returns whether the timeout is elapsed.
+func (t *wallTimeout) IsElapsed(context.Context) bool {
+	return !time.Now().Before(t.when)
+}
+
+// Wait waits for the timeout or until the context is cancelled.
+func (t *wallTimeout) Wait(ctx context.Context) error {
+	timer := time.NewTimer(time.Until(t.when))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Error returns the error message including the wait duration.
+func (e FundingTimeoutError) Error() string {
+	msg := e.FundingTimeoutError.Error()
+	if e.Waited == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%s: waited %v", msg, e.Waited.Round(time.Millisecond))
+}
+
+// Cause returns the wrapped pchannel.FundingTimeoutError.
+func (e FundingTimeoutError) Cause() error {
+	return e.FundingTimeoutError
+}
+
+// Unwrap returns the wrapped pchannel.FundingTimeoutError.
+func (e FundingTimeoutError) Unwrap() error {
+	return e.FundingTimeoutError
+}
+
+// makeTimeoutErr returns a FundingTimeoutError for the remaining peers which
+// were waited for since `since`.
+func makeTimeoutErr(remains map[channel.FundingID]pchannel.Index, since time.Time) error {
+	indices := make([]pchannel.Index, 0, len(remains))
+	for _, idx := range remains {
+		indices = append(indices, idx)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+
+	return FundingTimeoutError{
+		FundingTimeoutError: pchannel.FundingTimeoutError{
+			Errors: []*pchannel.AssetFundingError{{
+				Asset:         channel.Asset.Index(),
+				TimedOutPeers: indices,
+			}},
+		},
+		Waited: time.Since(since),
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"context"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
+	pchannel "perun.network/go-perun/channel"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+)
+
+// HistoryEntry is an event of a channel together with the block that
+// contains it.
+type HistoryEntry struct {
+	// Block is the number of the block.
+	Block types.BlockNumber
+	// BlockHash is the hash of the block.
+	BlockHash types.Hash
+	// Time is the on-chain time of the block.
+	Time time.Time
+	// Submitter is the account that signed the extrinsic which emitted the
+	// event. It is nil if the event was not emitted by a signed extrinsic.
+	Submitter *types.AccountID
+	// Event is one of DepositedEvent, DisputedEvent, ProgressedEvent,
+	// ConcludedEvent and WithdrawnEvent.
+	Event channel.PerunEvent
+}
+
+// ChannelHistory returns all events of a channel in the blocks from
+// `fromBlock` to `toBlock`, both inclusive, in chain order. `toBlock` is
+// capped at the latest block. The params are needed and not only the
+// channel ID, since deposits and withdrawals are identified by funding IDs,
+// which are derived from the channel ID and the participants.
+//
+// Substrate clears the event topics in every block, so they cannot be used
+// to find the blocks of a channel. Instead, the events of every block in the
+// range are read with state_getStorage, which public nodes offer. Only the
+// blocks that contain events of the channel are fetched. The node must still
+// have the state of the blocks, which archive nodes keep. The context is
+// checked before every block, so callers bound the work with the range or
+// the context.
+func (p *Pallet) ChannelHistory(ctx context.Context, params *pchannel.Params, fromBlock, toBlock types.BlockNumber, chain substrate.HistoryReader) ([]HistoryEntry, error) {
+	isChannelEvent, err := channelEventPredicate(params)
+	if err != nil {
+		return nil, err
+	}
+	key, err := substrate.SystemEventsKey().Key(chain.Metadata())
+	if err != nil {
+		return nil, err
+	}
+	head, err := chain.LastHeader()
+	if err != nil {
+		return nil, err
+	}
+	if toBlock > head.Number {
+		toBlock = head.Number
+	}
+
+	var history []HistoryEntry
+	for n := uint64(fromBlock); n <= uint64(toBlock); n++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hash, err := chain.BlockHash(n)
+		if err != nil {
+			return nil, err
+		}
+		data, err := chain.QueryAt(hash, key)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "querying events of block %d", n)
+		}
+		entries, err := p.blockHistory(hash, data, isChannelEvent, chain)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, entries...)
+	}
+	return history, nil
+}
+
+// blockHistory returns the history entries of all events of a block that
+// match the predicate.
+func (p *Pallet) blockHistory(hash types.Hash, data types.StorageDataRaw, pred EventPredicate, chain substrate.HistoryReader) ([]HistoryEntry, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	records, err := channel.DecodeEventRecords(types.EventRecordsRaw(data), chain.Metadata())
+	if err != nil {
+		return nil, errors.WithMessagef(err, "decoding events of block 0x%x", hash)
+	}
+	var events []channel.PerunEvent
+	for _, e := range records.Events() {
+		if pred(e) {
+			events = append(events, e)
+		}
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	block, err := chain.Block(hash)
+	if err != nil {
+		return nil, err
+	}
+	now, err := chain.TimestampAt(hash)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]HistoryEntry, len(events))
+	for i, e := range events {
+		entries[i] = HistoryEntry{
+			Block:     block.Block.Header.Number,
+			BlockHash: hash,
+			Time:      now,
+			Submitter: submitter(block, channel.EventPhase(e)),
+			Event:     e,
+		}
+	}
+	return entries, nil
+}
+
+// channelEventPredicate returns a predicate that matches all events of a
+// channel.
+func channelEventPredicate(params *pchannel.Params) (EventPredicate, error) {
+	cid := params.ID()
+	fids := make(map[channel.FundingID]bool, len(params.Parts))
+	for _, part := range params.Parts {
+		fid, err := calcFid(cid, part)
+		if err != nil {
+			return nil, err
+		}
+		fids[fid] = true
+	}
+
+	return func(e channel.PerunEvent) bool {
+		switch e := e.(type) {
+		case *channel.DepositedEvent:
+			return fids[e.Fid]
+		case *channel.DisputedEvent:
+			return e.Cid == cid
+		case *channel.ProgressedEvent:
+			return e.Cid == cid
+		case *channel.ConcludedEvent:
+			return e.Cid == cid
+		case *channel.WithdrawnEvent:
+			return fids[e.Fid]
+		default:
+			return false
+		}
+	}, nil
+}
+
+// submitter returns the signer of the extrinsic of the phase or nil if it
+// was not signed.
+func submitter(block *types.SignedBlock, phase types.Phase) *types.AccountID {
+	if !phase.IsApplyExtrinsic || int(phase.AsApplyExtrinsic) >= len(block.Block.Extrinsics) {
+		return nil
+	}
+	signer, err := substrate.ExtSignerID(&block.Block.Extrinsics[phase.AsApplyExtrinsic])
+	if err != nil {
+		return nil
+	}
+	return &signer
+}
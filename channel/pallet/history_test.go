@@ -0,0 +1,109 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet"
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet/test"
+	chtest "github.com/perun-network/perun-polkadot-backend/channel/test"
+	"github.com/perun-network/perun-polkadot-backend/wallet"
+)
+
+func TestPallet_ChannelHistory(t *testing.T) {
+	s := test.NewSetup(t)
+	req, params, state := newAdjReq(s, true)
+	dSetup := chtest.NewDepositSetup(params, state)
+	ctx := s.NewCtx()
+	head, err := s.API.LastHeader()
+	require.NoError(t, err)
+	// The range is capped at the latest block.
+	const latest = math.MaxUint32
+
+	// A new channel has no history.
+	history, err := s.Pallet.ChannelHistory(ctx, params, head.Number, latest, s.API)
+	require.NoError(t, err)
+	assert.Empty(t, history)
+
+	// Fund, conclude and withdraw.
+	require.NoError(t, test.FundAll(ctx, s.Funders, dSetup.FReqs))
+	adjAlice := pallet.NewAdjudicator(s.Alice.Acc, s.Pallet, s.API, test.PastBlocks)
+	require.NoError(t, adjAlice.Withdraw(ctx, req, nil))
+	req.Idx, req.Acc = 1, s.Bob.Acc
+	adjBob := pallet.NewAdjudicator(s.Bob.Acc, s.Pallet, s.API, test.PastBlocks)
+	require.NoError(t, adjBob.Withdraw(ctx, req, nil))
+
+	history, err = s.Pallet.ChannelHistory(ctx, params, head.Number, latest, s.API)
+	require.NoError(t, err)
+	alice := wallet.AsAddr(s.Alice.Acc.Address()).AccountID()
+	bob := wallet.AsAddr(s.Bob.Acc.Address()).AccountID()
+	var deposited, concluded, withdrawn int
+	for i, entry := range history {
+		if i > 0 {
+			assert.GreaterOrEqual(t, entry.Block, history[i-1].Block, "history must be ordered")
+		}
+		assert.False(t, entry.Time.IsZero())
+		require.NotNil(t, entry.Submitter)
+		switch e := entry.Event.(type) {
+		case *channel.DepositedEvent:
+			deposited++
+			assert.Zero(t, concluded, "deposit after conclude")
+			if e.Fid == dSetup.FIDs[0] {
+				assert.Equal(t, alice, *entry.Submitter)
+			} else {
+				assert.Equal(t, bob, *entry.Submitter)
+			}
+		case *channel.ConcludedEvent:
+			concluded++
+			assert.Equal(t, state.ID, e.Cid)
+		case *channel.WithdrawnEvent:
+			withdrawn++
+			assert.Equal(t, 1, concluded, "withdrawal before conclude")
+		}
+	}
+	assert.Equal(t, 2, deposited)
+	assert.Equal(t, 1, concluded)
+	assert.Equal(t, 2, withdrawn)
+
+	// Starting after the last event returns nothing.
+	require.NotEmpty(t, history)
+	history0 := history
+	last := history[len(history)-1].Block
+	history, err = s.Pallet.ChannelHistory(ctx, params, last+1, latest, s.API)
+	require.NoError(t, err)
+	assert.Empty(t, history)
+	// Ending before the first event returns nothing.
+	first := history0[0].Block
+	history, err = s.Pallet.ChannelHistory(ctx, params, head.Number, first-1, s.API)
+	require.NoError(t, err)
+	assert.Empty(t, history)
+	// Ending at the last event returns everything.
+	history, err = s.Pallet.ChannelHistory(ctx, params, head.Number, last, s.API)
+	require.NoError(t, err)
+	assert.Equal(t, history0, history)
+
+	// A cancelled context aborts the query.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = s.Pallet.ChannelHistory(cancelled, params, head.Number, latest, s.API)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,443 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
+
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+)
+
+type (
+	// Journal persists the on-chain operations of Funders, Depositors and
+	// Adjudicators. It allows to resume or reconcile operations that were
+	// interrupted by a crash instead of sending them twice.
+	Journal interface {
+		// Record persists an entry. The entry must be durable when Record
+		// returns.
+		Record(JournalEntry) error
+		// Last returns the latest entry of an operation and false if there
+		// is none.
+		Last(OpKey) (JournalEntry, bool, error)
+		// Pending returns the latest entry of all operations that are not
+		// done and did not fail.
+		Pending() ([]JournalEntry, error)
+	}
+
+	// OpKind is the kind of a journaled operation.
+	OpKind string
+
+	// OpKey identifies a journaled operation.
+	OpKey struct {
+		Kind OpKind `json:"kind"`
+		// ID is the funding ID for deposits and withdrawals and the channel
+		// ID otherwise.
+		ID types.Hash `json:"id"`
+	}
+
+	// OpStatus is the status of a journaled operation.
+	OpStatus string
+
+	// JournalEntry records the status of an operation at one point in time.
+	JournalEntry struct {
+		Op     OpKey    `json:"op"`
+		Status OpStatus `json:"status"`
+		// Nonce is the nonce of the Extrinsic.
+		Nonce uint64 `json:"nonce"`
+		// ExtHash is the hash of the Extrinsic.
+		ExtHash types.Hash `json:"extHash"`
+		// Block is the number of the latest block when the operation was
+		// journaled. A resumed Extrinsic is searched from there on.
+		Block types.BlockNumber `json:"block"`
+		// Ext is the SCALE encoded signed Extrinsic.
+		Ext []byte `json:"ext"`
+		// Error is set if the operation failed.
+		Error string    `json:"error,omitempty"`
+		Time  time.Time `json:"time"`
+	}
+)
+
+const (
+	// OpDeposit deposits funds for a funding ID.
+	OpDeposit OpKind = "deposit"
+	// OpDispute disputes a channel.
+	OpDispute OpKind = "dispute"
+	// OpProgress progresses a channel.
+	OpProgress OpKind = "progress"
+	// OpConclude concludes a channel.
+	OpConclude OpKind = "conclude"
+	// OpWithdraw withdraws the funds of a funding ID.
+	OpWithdraw OpKind = "withdraw"
+
+	// OpIntent the Extrinsic was signed but maybe not submitted yet.
+	OpIntent OpStatus = "intent"
+	// OpSubmitted the Extrinsic was accepted by the transaction pool.
+	OpSubmitted OpStatus = "submitted"
+	// OpDone the Extrinsic was included and its call succeeded.
+	OpDone OpStatus = "done"
+	// OpFailed the Extrinsic could not be submitted, its call failed or
+	// another Extrinsic used its nonce.
+	OpFailed OpStatus = "failed"
+)
+
+// NewJournalEntry returns a new entry with status OpIntent for a signed
+// Extrinsic.
+func NewJournalEntry(op OpKey, ext *types.Extrinsic) (JournalEntry, error) {
+	data, err := types.EncodeToBytes(ext)
+	if err != nil {
+		return JournalEntry{}, err
+	}
+	hash, err := substrate.ExtHash(ext)
+	if err != nil {
+		return JournalEntry{}, err
+	}
+	return JournalEntry{
+		Op:      op,
+		Status:  OpIntent,
+		Nonce:   substrate.ExtNonce(ext),
+		ExtHash: hash,
+		Ext:     data,
+		Time:    time.Now(),
+	}, nil
+}
+
+// Pending returns whether the operation can still be resumed.
+func (e JournalEntry) Pending() bool {
+	return e.Status == OpIntent || e.Status == OpSubmitted
+}
+
+// Extrinsic decodes the journaled Extrinsic.
+func (e JournalEntry) Extrinsic() (*types.Extrinsic, error) {
+	var ext types.Extrinsic
+	if err := types.DecodeFromBytes(e.Ext, &ext); err != nil {
+		return nil, errors.WithMessage(err, "decoding journaled extrinsic")
+	}
+	return &ext, nil
+}
+
+// with returns a copy of the entry with an updated status.
+func (e JournalEntry) with(status OpStatus, err error) JournalEntry {
+	e.Status = status
+	e.Time = time.Now()
+	e.Error = ""
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// nonceInterval is the interval in which the account nonce is polled while
+// waiting for a resumed Extrinsic.
+const nonceInterval = time.Second
+
+// waitFunc blocks until a sent Extrinsic is finalized or the context is
+// cancelled.
+type waitFunc func(context.Context) error
+
+// transact sends an Extrinsic and waits for it to be finalized.
+// Returns the error of the call if the pallet rejected it.
+// See send.
+func transact(ctx context.Context, p *Pallet, journal Journal, op OpKey, ext *types.Extrinsic) error {
+	wait, _, err := send(p, journal, op, ext)
+	if err != nil {
+		return err
+	}
+	return wait(ctx)
+}
+
+// send submits an Extrinsic and returns a function to wait for its
+// finalization, which must be called, and whether `ext` itself was
+// submitted.
+// If `journal` is not nil, the operation is journaled. An unfinished
+// Extrinsic of a previous run with the same call is then resumed in place of
+// `ext`, which is discarded. `ext` is only submitted if another Extrinsic
+// used the nonce of the resumed one.
+func send(p *Pallet, journal Journal, op OpKey, ext *types.Extrinsic) (waitFunc, bool, error) {
+	if journal == nil {
+		wait, err := submit(p, ext)
+		return wait, err == nil, err
+	}
+
+	last, found, err := journal.Last(op)
+	if err != nil {
+		return nil, false, err
+	}
+	if found && last.Pending() {
+		prev, err := last.Extrinsic()
+		if err != nil {
+			return nil, false, err
+		}
+		if same, err := sameCall(prev, ext); err != nil {
+			return nil, false, err
+		} else if !same {
+			p.Log().WithField("op", op).Warn("Superseding unfinished operation with a different call")
+		} else if wait, err := resume(p, journal, last, prev); !errors.Is(err, substrate.ErrExtUsurped) {
+			return wait, false, err
+		} else {
+			p.Log().WithField("op", op).WithError(err).Warn("Journaled extrinsic was replaced, submitting again")
+		}
+	}
+
+	entry, err := NewJournalEntry(op, ext)
+	if err != nil {
+		return nil, false, err
+	}
+	if entry.Block, err = p.BlockNumber(); err != nil {
+		return nil, false, err
+	}
+	if err := journal.Record(entry); err != nil {
+		return nil, false, err
+	}
+	wait, err := submitJournaled(p, journal, entry, ext)
+	return wait, err == nil, err
+}
+
+// resume continues a journaled operation. If the nonce of its Extrinsic was
+// used, the outcome is recorded, see recordOutcome. Otherwise the Extrinsic
+// is submitted again.
+func resume(p *Pallet, journal Journal, entry JournalEntry, ext *types.Extrinsic) (waitFunc, error) {
+	log := p.Log().WithField("op", entry.Op).WithField("hash", entry.ExtHash.Hex())
+	if used, err := nonceUsed(p, ext); err != nil {
+		return nil, err
+	} else if used {
+		log.Debug("Nonce of journaled extrinsic was already used")
+		if err := recordOutcome(p, journal, entry, ext); err != nil {
+			return nil, err
+		}
+		return noWait, nil
+	}
+	log.Debug("Resubmitting journaled extrinsic")
+	return submitJournaled(p, journal, entry, ext)
+}
+
+// submitJournaled submits a journaled Extrinsic and records its status.
+func submitJournaled(p *Pallet, journal Journal, entry JournalEntry, ext *types.Extrinsic) (waitFunc, error) {
+	sub, err := p.Transact(ext)
+	if isAlreadyImported(err) {
+		// The Extrinsic is still in the pool from a previous run.
+		if err := journal.Record(entry.with(OpSubmitted, nil)); err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context) error {
+			if err := waitNonceUsed(ctx, p, ext); err != nil {
+				return err
+			}
+			return recordOutcome(p, journal, entry, ext)
+		}, nil
+	} else if err != nil {
+		if rerr := journal.Record(entry.with(OpFailed, err)); rerr != nil {
+			p.Log().WithError(rerr).Error("Could not journal failed extrinsic")
+		}
+		return nil, err
+	}
+
+	if err := journal.Record(entry.with(OpSubmitted, nil)); err != nil {
+		sub.Close()
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		defer sub.Close()
+		// The entry stays submitted if the context is cancelled, so that
+		// the operation can be resumed. An Extrinsic that left the pool or
+		// whose call returned an error failed and must not be resumed.
+		if err := waitResult(ctx, p, sub, ext); err != nil {
+			if extFailed(err) || errors.Is(err, substrate.ErrCallFailed) {
+				if rerr := journal.Record(entry.with(OpFailed, err)); rerr != nil {
+					p.Log().WithError(rerr).Error("Could not journal failed extrinsic")
+				}
+			}
+			return err
+		}
+		return journal.Record(entry.with(OpDone, nil))
+	}, nil
+}
+
+// reconcile records the outcome of the pending journaled operation `op` if
+// the nonce of its Extrinsic was used. This makes the on-chain effects of an
+// operation that was included before a crash visible before the caller
+// decides what to send. Operations whose nonce is unused stay pending so
+// that send resumes them. A failed operation is only recorded.
+func reconcile(p *Pallet, journal Journal, op OpKey) error {
+	if journal == nil {
+		return nil
+	}
+	last, found, err := journal.Last(op)
+	if err != nil || !found || !last.Pending() {
+		return err
+	}
+	ext, err := last.Extrinsic()
+	if err != nil {
+		return err
+	}
+	if used, err := nonceUsed(p, ext); err != nil || !used {
+		return err
+	}
+	if err := recordOutcome(p, journal, last, ext); err != nil && !opFailed(err) {
+		return err
+	} else if err != nil {
+		p.Log().WithField("op", op).WithError(err).Warn("Journaled operation failed")
+	}
+	return nil
+}
+
+// resumePending resumes all pending operations of the journal whose kind is
+// one of `kinds` and waits for them. Operations that fail are recorded as
+// failed and skipped. Returns the first error that left an operation
+// pending, or nil if `journal` is nil.
+func resumePending(ctx context.Context, p *Pallet, journal Journal, kinds ...OpKind) error {
+	if journal == nil {
+		return nil
+	}
+	pending, err := journal.Pending()
+	if err != nil {
+		return err
+	}
+	for _, entry := range pending {
+		if !hasKind(entry.Op.Kind, kinds) {
+			continue
+		}
+		log := p.Log().WithField("op", entry.Op)
+		ext, err := entry.Extrinsic()
+		if err != nil {
+			return err
+		}
+		log.Debug("Resuming journaled operation")
+		wait, err := resume(p, journal, entry, ext)
+		if err == nil {
+			err = wait(ctx)
+		}
+		if err != nil && (!opFailed(err) || ctx.Err() != nil) {
+			return err
+		} else if err != nil {
+			log.WithError(err).Warn("Journaled operation failed")
+		}
+	}
+	return nil
+}
+
+// opFailed returns whether an error of a journaled operation means that it
+// was recorded as failed.
+func opFailed(err error) bool {
+	return extFailed(err) || errors.Is(err, substrate.ErrCallFailed)
+}
+
+// hasKind returns whether `kinds` contains `kind`.
+func hasKind(kind OpKind, kinds []OpKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// recordOutcome records the outcome of a journaled Extrinsic whose nonce was
+// used. The operation is done if the Extrinsic was included and its call
+// succeeded. Otherwise it failed and the error is returned, which wraps
+// substrate.ErrExtUsurped if another Extrinsic used the nonce.
+func recordOutcome(p *Pallet, journal Journal, entry JournalEntry, ext *types.Extrinsic) error {
+	block, found, err := p.FindExt(entry.ExtHash, entry.Block)
+	if err != nil {
+		return err
+	}
+	if !found {
+		err = errors.WithMessagef(substrate.ErrExtUsurped, "nonce %d used by another extrinsic", entry.Nonce)
+	} else if dErr, rerr := p.dispatchError(block, ext); rerr != nil {
+		// The outcome is unknown, the operation stays pending.
+		return rerr
+	} else if dErr != nil {
+		err = p.DecodeError(*dErr)
+	}
+	if err != nil {
+		if rerr := journal.Record(entry.with(OpFailed, err)); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+	return journal.Record(entry.with(OpDone, nil))
+}
+
+// submit submits an Extrinsic without journaling it.
+func submit(p *Pallet, ext *types.Extrinsic) (waitFunc, error) {
+	sub, err := p.Transact(ext)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		defer sub.Close()
+		p.Log().Trace("waiting for TX confirmation")
+		return waitResult(ctx, p, sub, ext)
+	}, nil
+}
+
+// noWait is a waitFunc for Extrinsics that are already finalized.
+func noWait(context.Context) error { return nil }
+
+// waitNonceUsed blocks until the nonce of the Extrinsic was used or the
+// context is cancelled.
+func waitNonceUsed(ctx context.Context, p *Pallet, ext *types.Extrinsic) error {
+	ticker := time.NewTicker(nonceInterval)
+	defer ticker.Stop()
+	for {
+		if used, err := nonceUsed(p, ext); err != nil || used {
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// nonceUsed returns whether the signer of the Extrinsic already used its
+// nonce. This is the case if the Extrinsic, or another one that replaced it,
+// was included in a block.
+func nonceUsed(p *Pallet, ext *types.Extrinsic) (bool, error) {
+	signer, err := substrate.ExtSignerID(ext)
+	if err != nil {
+		return false, err
+	}
+	nonce, err := p.AccountNonce(signer)
+	if err != nil {
+		return false, err
+	}
+	return nonce > substrate.ExtNonce(ext), nil
+}
+
+// sameCall returns whether two Extrinsics have the same call and arguments.
+func sameCall(a, b *types.Extrinsic) (bool, error) {
+	callA, err := types.EncodeToBytes(a.Method)
+	if err != nil {
+		return false, err
+	}
+	callB, err := types.EncodeToBytes(b.Method)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(callA, callB), nil
+}
+
+// isAlreadyImported returns whether the transaction pool rejected an
+// Extrinsic because it already contains it.
+func isAlreadyImported(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Already Imported")
+}
@@ -0,0 +1,318 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet_test
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pkgerrors "polycry.pt/poly-go/errors"
+	pkgtest "polycry.pt/poly-go/test"
+
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet"
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet/test"
+	chtest "github.com/perun-network/perun-polkadot-backend/channel/test"
+	"github.com/perun-network/perun-polkadot-backend/wallet"
+)
+
+func TestFileJournal(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	dir, err := ioutil.TempDir("", "journal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	j, err := pallet.NewFileJournal(path)
+	require.NoError(t, err)
+	var dep, dis pallet.OpKey
+	dep.Kind, dis.Kind = pallet.OpDeposit, pallet.OpDispute
+	rng.Read(dep.ID[:])
+	rng.Read(dis.ID[:])
+
+	// Record a deposit that is done and a dispute that is pending.
+	now := time.Now()
+	entries := []pallet.JournalEntry{
+		{Op: dep, Status: pallet.OpIntent, Nonce: 1, Ext: []byte{1}, Time: now},
+		{Op: dis, Status: pallet.OpSubmitted, Nonce: 2, Ext: []byte{2}, Time: now.Add(time.Second)},
+		{Op: dep, Status: pallet.OpDone, Nonce: 1, Ext: []byte{1}, Time: now.Add(2 * time.Second)},
+	}
+	for _, e := range entries {
+		require.NoError(t, j.Record(e))
+	}
+	assertJournal := func(j pallet.Journal) {
+		last, found, err := j.Last(dep)
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, pallet.OpDone, last.Status)
+
+		pending, err := j.Pending()
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		assert.Equal(t, dis, pending[0].Op)
+		assert.Equal(t, []byte{2}, pending[0].Ext)
+
+		_, found, err = j.Last(pallet.OpKey{Kind: pallet.OpWithdraw})
+		require.NoError(t, err)
+		assert.False(t, found)
+	}
+	assertJournal(j)
+	require.NoError(t, j.Close())
+
+	// Simulate a crash while writing an entry.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"op":{"kind":"dispu`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// The entries are restored and the incomplete line is dropped.
+	j, err = pallet.NewFileJournal(path)
+	require.NoError(t, err)
+	assertJournal(j)
+	require.NoError(t, j.Record(pallet.JournalEntry{Op: dis, Status: pallet.OpDone, Time: now}))
+	require.NoError(t, j.Close())
+
+	j, err = pallet.NewFileJournal(path)
+	require.NoError(t, err)
+	defer j.Close()
+	pending, err := j.Pending()
+	require.NoError(t, err)
+	assert.Len(t, pending, 0)
+}
+
+func TestFileJournal_Corrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+	require.NoError(t, ioutil.WriteFile(path, []byte("{}\nnot json\n{}\n"), 0o600))
+
+	_, err = pallet.NewFileJournal(path)
+	assert.Error(t, err)
+}
+
+// TestDepositor_JournalResume checks that a journaled deposit that was not
+// submitted before a crash is resumed instead of being signed again.
+func TestDepositor_JournalResume(t *testing.T) {
+	s := test.NewSetup(t)
+	params, state := s.NewRandomParamAndState()
+	dSetup := chtest.NewDepositSetup(params, state, s.Alice.Acc)
+	journal := newFileJournal(t)
+	dep := pallet.NewDepositor(s.Pallet, pallet.WithDepositorJournal(journal))
+	req := dSetup.DReqs[0]
+	op := pallet.OpKey{Kind: pallet.OpDeposit, ID: req.FundingID}
+
+	// The deposit was signed and journaled but the process crashed.
+	ext, err := s.Pallet.BuildDeposit(req.Payer, req.Balance, req.FundingID)
+	require.NoError(t, err)
+	entry, err := pallet.NewJournalEntry(op, ext)
+	require.NoError(t, err)
+	require.NoError(t, journal.Record(entry))
+
+	// Retrying sends the journaled extrinsic.
+	require.NoError(t, dep.Deposit(s.NewCtx(), req))
+	last, found, err := journal.Last(op)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, pallet.OpDone, last.Status)
+	assert.Equal(t, entry.ExtHash, last.ExtHash)
+	s.AssertDeposit(req.FundingID, req.Balance)
+}
+
+// TestDepositor_JournalReconcile checks that a journaled deposit that was
+// included before a crash is not sent again.
+func TestDepositor_JournalReconcile(t *testing.T) {
+	s := test.NewSetup(t)
+	params, state := s.NewRandomParamAndState()
+	dSetup := chtest.NewDepositSetup(params, state, s.Alice.Acc)
+	journal := newFileJournal(t)
+	dep := pallet.NewDepositor(s.Pallet, pallet.WithDepositorJournal(journal))
+	req := dSetup.DReqs[0]
+	op := pallet.OpKey{Kind: pallet.OpDeposit, ID: req.FundingID}
+	ctx := s.NewCtx()
+
+	// The deposit was submitted and included but the process crashed
+	// before journaling the outcome.
+	ext, err := s.Pallet.BuildDeposit(req.Payer, req.Balance, req.FundingID)
+	require.NoError(t, err)
+	entry, err := pallet.NewJournalEntry(op, ext)
+	require.NoError(t, err)
+	entry.Status = pallet.OpSubmitted
+	require.NoError(t, journal.Record(entry))
+	require.NoError(t, pallet.NewDepositor(s.Pallet).Deposit(ctx, req))
+
+	// Retrying only reconciles the journal.
+	alice := wallet.AsAddr(s.Alice.Acc.Address()).AccountID()
+	s.AssertBalanceChanges(map[types.AccountID]*big.Int{alice: big.NewInt(0)}, big.NewInt(0), func() {
+		require.NoError(t, dep.Deposit(ctx, req))
+	})
+	last, _, err := journal.Last(op)
+	require.NoError(t, err)
+	assert.Equal(t, pallet.OpDone, last.Status)
+	s.AssertDeposit(req.FundingID, req.Balance)
+}
+
+// TestDepositor_JournalReplaced checks that a journaled deposit whose nonce
+// was used by another extrinsic is not considered done but sent again.
+func TestDepositor_JournalReplaced(t *testing.T) {
+	s := test.NewSetup(t)
+	params, state := s.NewRandomParamAndState()
+	dSetup := chtest.NewDepositSetup(params, state, s.Alice.Acc, s.Alice.Acc)
+	journal := newFileJournal(t)
+	dep := pallet.NewDepositor(s.Pallet, pallet.WithDepositorJournal(journal))
+	req, other := dSetup.DReqs[0], dSetup.DReqs[1]
+	op := pallet.OpKey{Kind: pallet.OpDeposit, ID: req.FundingID}
+	ctx := s.NewCtx()
+
+	// The deposit was journaled but another extrinsic with the same nonce
+	// was included instead.
+	ext, err := s.Pallet.BuildDeposit(req.Payer, req.Balance, req.FundingID)
+	require.NoError(t, err)
+	entry, err := pallet.NewJournalEntry(op, ext)
+	require.NoError(t, err)
+	entry.Status = pallet.OpSubmitted
+	require.NoError(t, journal.Record(entry))
+	require.NoError(t, pallet.NewDepositor(s.Pallet).Deposit(ctx, other))
+
+	// Retrying sends the deposit again.
+	require.NoError(t, dep.Deposit(ctx, req))
+	last, _, err := journal.Last(op)
+	require.NoError(t, err)
+	assert.Equal(t, pallet.OpDone, last.Status)
+	assert.NotEqual(t, entry.ExtHash, last.ExtHash)
+	s.AssertDeposit(req.FundingID, req.Balance)
+	s.AssertDeposit(other.FundingID, other.Balance)
+}
+
+// TestFunder_JournalReconcile checks that a journaled deposit that was
+// included before a crash is recorded as done although the share is covered
+// and nothing is deposited.
+func TestFunder_JournalReconcile(t *testing.T) {
+	s := test.NewSetup(t)
+	params, state := s.NewRandomParamAndState()
+	dSetup := chtest.NewDepositSetup(params, state, s.Alice.Acc, s.Bob.Acc)
+	journal := newFileJournal(t)
+	funder := pallet.NewFunder(s.Pallet, s.Alice.Acc, s.API, test.PastBlocks, pallet.WithFunderJournal(journal))
+	req := dSetup.DReqs[0]
+	op := pallet.OpKey{Kind: pallet.OpDeposit, ID: req.FundingID}
+	ctx := s.NewCtx()
+
+	// The deposit was included but the outcome was not journaled.
+	ext, err := s.Pallet.BuildDeposit(req.Payer, req.Balance, req.FundingID)
+	require.NoError(t, err)
+	entry, err := pallet.NewJournalEntry(op, ext)
+	require.NoError(t, err)
+	entry.Status = pallet.OpSubmitted
+	require.NoError(t, journal.Record(entry))
+	require.NoError(t, pallet.NewDepositor(s.Pallet).Deposit(ctx, req))
+
+	g := pkgerrors.NewGatherer()
+	var res *pallet.FundingResult
+	g.Go(func() (err error) {
+		res, err = funder.FundWithResult(ctx, *dSetup.FReqs[0])
+		return
+	})
+	g.Go(func() error { return s.Funders[1].Fund(ctx, *dSetup.FReqs[1]) })
+	require.NoError(t, g.Wait())
+
+	assert.Zero(t, res.Transferred.Sign())
+	last, _, err := journal.Last(op)
+	require.NoError(t, err)
+	assert.Equal(t, pallet.OpDone, last.Status)
+	s.AssertDeposits(dSetup.FIDs, dSetup.FinalBals)
+}
+
+// TestFunder_ResumePending checks that the Funder resumes a deposit that a
+// journal of a previous run holds as pending.
+func TestFunder_ResumePending(t *testing.T) {
+	s := test.NewSetup(t)
+	params, state := s.NewRandomParamAndState()
+	dSetup := chtest.NewDepositSetup(params, state, s.Alice.Acc)
+	journal := newFileJournal(t)
+	req := dSetup.DReqs[0]
+	op := pallet.OpKey{Kind: pallet.OpDeposit, ID: req.FundingID}
+
+	// The deposit was signed and journaled but the process crashed.
+	ext, err := s.Pallet.BuildDeposit(req.Payer, req.Balance, req.FundingID)
+	require.NoError(t, err)
+	entry, err := pallet.NewJournalEntry(op, ext)
+	require.NoError(t, err)
+	require.NoError(t, journal.Record(entry))
+
+	funder := pallet.NewFunder(s.Pallet, s.Alice.Acc, s.API, test.PastBlocks, pallet.WithFunderJournal(journal))
+	require.NoError(t, funder.ResumePending(s.NewCtx()))
+	last, _, err := journal.Last(op)
+	require.NoError(t, err)
+	assert.Equal(t, pallet.OpDone, last.Status)
+	assert.Equal(t, entry.ExtHash, last.ExtHash)
+	s.AssertDeposit(req.FundingID, req.Balance)
+	pending, err := journal.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+// TestAdjudicator_ResumePending checks that the Adjudicator resumes a
+// dispute that a journal of a previous run holds as pending and leaves the
+// operations of other kinds alone.
+func TestAdjudicator_ResumePending(t *testing.T) {
+	s := test.NewSetup(t)
+	req, params, state := newAdjReq(s, false)
+	journal := newFileJournal(t)
+
+	// A dispute and a deposit were journaled but the process crashed.
+	dispute, err := s.Pallet.BuildDispute(s.Alice.Acc, params, state, req.Tx.Sigs)
+	require.NoError(t, err)
+	disputeEntry, err := pallet.NewJournalEntry(pallet.OpKey{Kind: pallet.OpDispute, ID: params.ID()}, dispute)
+	require.NoError(t, err)
+	require.NoError(t, journal.Record(disputeEntry))
+	dSetup := chtest.NewDepositSetup(params, state, s.Bob.Acc)
+	deposit, err := s.Pallet.BuildDeposit(s.Bob.Acc, dSetup.DReqs[0].Balance, dSetup.FIDs[0])
+	require.NoError(t, err)
+	depositEntry, err := pallet.NewJournalEntry(pallet.OpKey{Kind: pallet.OpDeposit, ID: dSetup.FIDs[0]}, deposit)
+	require.NoError(t, err)
+	require.NoError(t, journal.Record(depositEntry))
+
+	adj := pallet.NewAdjudicator(s.Alice.Acc, s.Pallet, s.API, test.PastBlocks, pallet.WithAdjudicatorJournal(journal))
+	require.NoError(t, adj.ResumePending(s.NewCtx()))
+	reg, err := s.Pallet.QueryStateRegister(params.ID(), s.API, test.PastBlocks)
+	require.NoError(t, err)
+	assert.Equal(t, state.Version, reg.State.Version)
+	pending, err := journal.Pending()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, depositEntry.Op, pending[0].Op)
+	s.AssertNoDeposit(dSetup.FIDs[0])
+}
+
+// newFileJournal returns a FileJournal in a temporary directory that is
+// removed when the test ends.
+func newFileJournal(t *testing.T) *pallet.FileJournal {
+	dir, err := ioutil.TempDir("", "journal")
+	require.NoError(t, err)
+	j, err := pallet.NewFileJournal(filepath.Join(dir, "journal"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		j.Close()
+		os.RemoveAll(dir)
+	})
+	return j
+}
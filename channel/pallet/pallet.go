@@ -15,9 +15,11 @@
 package pallet
 
 import (
-	"errors"
+	"fmt"
+	"math/big"
 
 	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
 	pchannel "perun.network/go-perun/channel"
 	"perun.network/go-perun/log"
 	pwallet "perun.network/go-perun/wallet"
@@ -34,11 +36,20 @@ type Pallet struct {
 
 	*substrate.Pallet
 	meta *types.Metadata
+	hub  *EventHub
 }
 
-// PerunPallet is the name of the Perun pallet as configured by the
-// substrate chain.
-const PerunPallet = "PerunModule"
+const (
+	// PerunPallet is the name of the Perun pallet as configured by the
+	// substrate chain.
+	PerunPallet = "PerunModule"
+	// HasherConst is the name of the pallet constant that identifies the
+	// hasher which the pallet uses for channel and funding IDs.
+	HasherConst = "Hasher"
+	// TimeoutModeConst is the name of the pallet constant that identifies
+	// how the pallet interprets challenge durations.
+	TimeoutModeConst = "TimeoutMode"
+)
 
 var (
 	// Deposit is the name of the deposit function of the pallet.
@@ -61,8 +72,95 @@ var (
 )
 
 // NewPallet returns a new Pallet.
+// The caller should configure the channel Backend with ConfigureBackend
+// before it calculates channel or funding IDs.
 func NewPallet(pallet *substrate.Pallet, meta *types.Metadata) *Pallet {
-	return &Pallet{log.MakeEmbedding(log.Default()), pallet, meta}
+	return &Pallet{Embedding: log.MakeEmbedding(log.Default()), Pallet: pallet, meta: meta}
+}
+
+// ConfigureBackend sets the hasher and timeout mode of the channel Backend
+// to the values that the pallet exposes in its metadata, since all channel
+// and funding IDs are wrong with another hasher. The Backend is left
+// unchanged for values that the pallet does not expose.
+func ConfigureBackend(meta *types.Metadata) error {
+	h, found, err := findU8Const(meta, HasherConst)
+	if err != nil {
+		return errors.WithMessage(err, "decoding hasher")
+	}
+	if hasher := channel.Hasher(h); found && hasher != channel.Backend.Hasher() {
+		log.Infof("Using hasher %v of the pallet instead of %v", hasher, channel.Backend.Hasher())
+		if err := channel.Backend.SetHasher(hasher); err != nil {
+			return err
+		}
+	}
+	m, found, err := findU8Const(meta, TimeoutModeConst)
+	if err != nil {
+		return errors.WithMessage(err, "decoding timeout mode")
+	}
+	if mode := channel.TimeoutMode(m); found && mode != channel.Backend.TimeoutMode() {
+		log.Infof("Using timeout mode %v of the pallet instead of %v", mode, channel.Backend.TimeoutMode())
+		return channel.Backend.SetTimeoutMode(mode)
+	}
+	return nil
+}
+
+// WithEventHub returns a copy of the Pallet whose subscriptions are served
+// by the hub. Subscriptions that look further into the past than the hub
+// retains still use their own event source.
+func (p *Pallet) WithEventHub(hub *EventHub) *Pallet {
+	ret := *p
+	ret.hub = hub
+	return &ret
+}
+
+// DetectHasher reads the hasher of the pallet from the metadata.
+// Returns channel.DefaultHasher if the pallet does not expose the hasher.
+func DetectHasher(meta *types.Metadata) (channel.Hasher, error) {
+	h, found, err := findU8Const(meta, HasherConst)
+	if err != nil {
+		return 0, errors.WithMessage(err, "decoding hasher")
+	}
+	if !found {
+		log.Debugf("Using default hasher %v", channel.DefaultHasher)
+		return channel.DefaultHasher, nil
+	}
+	hasher := channel.Hasher(h)
+	return hasher, hasher.Valid()
+}
+
+// DetectTimeoutMode reads the timeout mode of the pallet from the metadata.
+// Returns channel.DefaultTimeoutMode if the pallet does not expose it.
+func DetectTimeoutMode(meta *types.Metadata) (channel.TimeoutMode, error) {
+	m, found, err := findU8Const(meta, TimeoutModeConst)
+	if err != nil {
+		return 0, errors.WithMessage(err, "decoding timeout mode")
+	}
+	if !found {
+		log.Debugf("Using default timeout mode %v", channel.DefaultTimeoutMode)
+		return channel.DefaultTimeoutMode, nil
+	}
+	mode := channel.TimeoutMode(m)
+	return mode, mode.Valid()
+}
+
+// findU8Const reads a u8 constant of the pallet from the metadata.
+// Returns false if the constant does not exist. All other errors, like an
+// unsupported metadata version, are returned.
+func findU8Const(meta *types.Metadata, name string) (uint8, bool, error) {
+	data, err := meta.FindConstantValue(PerunPallet, name)
+	if err != nil && isConstNotFound(err, name) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, errors.WithMessagef(err, "finding constant %s", name)
+	}
+	var ret uint8
+	return ret, true, channel.ScaleDecode(&ret, data)
+}
+
+// isConstNotFound returns whether err is the error that the metadata returns
+// for a missing constant of the pallet. gsrpc has no sentinel for it.
+func isConstNotFound(err error, name string) bool {
+	return err.Error() == fmt.Sprintf("could not find constant %s.%s", PerunPallet, name)
 }
 
 // NewPerunPallet is a wrapper around NewPallet and returns a new Perun pallet.
@@ -71,7 +169,11 @@ func NewPerunPallet(api *substrate.API) *substrate.Pallet {
 }
 
 // Subscribe returns an EventSub that listens on all events of the pallet.
+// Uses the EventHub of the Pallet if it has one.
 func (p *Pallet) Subscribe(f EventPredicate, pastBlocks types.BlockNumber) (*EventSub, error) {
+	if p.hub != nil && pastBlocks <= p.hub.History() {
+		return p.hub.Subscribe(f, pastBlocks)
+	}
 	source, err := p.Pallet.Subscribe(pastBlocks)
 	if err != nil {
 		return nil, err
@@ -124,7 +226,47 @@ func (p *Pallet) QueryStateRegister(cid channel.ChannelID, storage substrate.Sto
 	return ret, channel.ScaleDecode(ret, res.StorageData)
 }
 
+// QueryStateRegisterAt returns the registered state of a channel at the end
+// of a block or ErrNoRegisteredState if no state was registered.
+func (p *Pallet) QueryStateRegisterAt(cid channel.ChannelID, chain substrate.BlockQueryer, block types.Hash) (*channel.RegisteredState, error) {
+	key, err := p.BuildQuery("StateRegister", cid[:])
+	if err != nil {
+		return nil, err
+	}
+	p.Log().WithField("cid", cid).WithField("block", block).Trace("Querying stateRegister")
+	data, err := chain.QueryAt(block, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrNoRegisteredState
+	}
+	ret := new(channel.RegisteredState)
+	return ret, channel.ScaleDecode(ret, data)
+}
+
+// QueryDepositAt returns the deposit for a funding ID at the end of a block
+// or ErrNoDeposit if no deposit was found.
+func (p *Pallet) QueryDepositAt(fid channel.FundingID, chain substrate.BlockQueryer, block types.Hash) (*channel.Balance, error) {
+	key, err := p.BuildQuery("Deposits", fid[:])
+	if err != nil {
+		return nil, err
+	}
+	p.Log().WithField("fid", fid).WithField("block", block).Trace("Querying deposit")
+	data, err := chain.QueryAt(block, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrNoDeposit
+	}
+	ret := new(channel.Balance)
+	return ret, channel.ScaleDecode(ret, data)
+}
+
 // BuildDeposit returns an extrinsic that funds the specified funding ID.
+// The amount and the fees are paid by `acc`, which does not need to be the
+// participant of the funding ID.
 func (p *Pallet) BuildDeposit(acc pwallet.Account, _amount pchannel.Bal, fid channel.FundingID) (*types.Extrinsic, error) {
 	amount, err := channel.MakeBalance(_amount)
 	if err != nil {
@@ -137,8 +279,69 @@ func (p *Pallet) BuildDeposit(acc pwallet.Account, _amount pchannel.Bal, fid cha
 		wallet.AsAcc(acc))
 }
 
+// BuildDepositWithNonce is like BuildDeposit but signs the extrinsic with
+// the given nonce.
+func (p *Pallet) BuildDepositWithNonce(acc pwallet.Account, _amount pchannel.Bal, fid channel.FundingID, nonce uint64) (*types.Extrinsic, error) {
+	amount, err := channel.MakeBalance(_amount)
+	if err != nil {
+		return nil, err
+	}
+	return p.BuildExtWithNonce(Deposit, []interface{}{
+		fid,
+		amount},
+		wallet.AsAddr(acc.Address()).AccountID(),
+		wallet.AsAcc(acc),
+		nonce)
+}
+
+// CheckDeposit checks that `acc` can pay the deposit plus fees while
+// staying above the existential deposit. Returns
+// substrate.ErrInsufficientFunds otherwise. Nothing is signed.
+func (p *Pallet) CheckDeposit(acc pwallet.Account, _amount pchannel.Bal, fid channel.FundingID) error {
+	amount, err := channel.MakeBalance(_amount)
+	if err != nil {
+		return err
+	}
+	return p.CheckFunds(Deposit, []interface{}{
+		fid,
+		amount},
+		wallet.AsAddr(acc.Address()).AccountID(),
+		_amount)
+}
+
+// CheckDeposits checks that `acc` can pay all deposits plus their fees while
+// staying above the existential deposit. Returns
+// substrate.ErrInsufficientFunds otherwise. Nothing is signed.
+func (p *Pallet) CheckDeposits(acc pwallet.Account, amounts []pchannel.Bal, fids []channel.FundingID) error {
+	if len(amounts) != len(fids) {
+		return errors.Errorf("got %d amounts for %d funding IDs", len(amounts), len(fids))
+	}
+	if len(fids) == 0 {
+		return nil
+	}
+	total := new(big.Int)
+	for _, amount := range amounts {
+		if _, err := channel.MakeBalance(amount); err != nil {
+			return err
+		}
+		total.Add(total, amount)
+	}
+	// All deposit calls have the same size and therefore the same fee.
+	amount, _ := channel.MakeBalance(amounts[0])
+	return p.CheckBatchFunds(Deposit, []interface{}{
+		fids[0],
+		amount},
+		wallet.AsAddr(acc.Address()).AccountID(),
+		total,
+		len(fids))
+}
+
 // BuildDispute returns an extrinsic that disputes a channel.
+// Returns an error if the pallet would reject it, see ValidateDispute.
 func (p *Pallet) BuildDispute(acc pwallet.Account, params *pchannel.Params, state *pchannel.State, sigs []pwallet.Sig) (*types.Extrinsic, error) {
+	if err := ValidateDispute(params, state, sigs); err != nil {
+		return nil, err
+	}
 	_params, err := channel.NewParams(params)
 	if err != nil {
 		return nil, err
@@ -147,7 +350,7 @@ func (p *Pallet) BuildDispute(acc pwallet.Account, params *pchannel.Params, stat
 	if err != nil {
 		return nil, err
 	}
-	_sigs, err := channel.MakeSigs(sigs)
+	_sigs, err := makeSigs(sigs, len(_params.Participants))
 	if err != nil {
 		return nil, err
 	}
@@ -161,8 +364,13 @@ func (p *Pallet) BuildDispute(acc pwallet.Account, params *pchannel.Params, stat
 		wallet.AsAcc(acc))
 }
 
-// BuildProgress returns an extrinsic that progresses a channel.
-func (p *Pallet) BuildProgress(acc pwallet.Account, params *pchannel.Params, next *pchannel.State, sig pwallet.Sig, signer pchannel.Index) (*types.Extrinsic, error) {
+// BuildProgress returns an extrinsic that progresses a channel from the
+// registered state `from` to `next`.
+// Returns an error if the pallet would reject it, see ValidateProgress.
+func (p *Pallet) BuildProgress(acc pwallet.Account, params *pchannel.Params, from, next *pchannel.State, sig pwallet.Sig, signer pchannel.Index) (*types.Extrinsic, error) {
+	if err := ValidateProgress(params, from, next, sig, signer); err != nil {
+		return nil, err
+	}
 	_params, err := channel.NewParams(params)
 	if err != nil {
 		return nil, err
@@ -188,6 +396,8 @@ func (p *Pallet) BuildProgress(acc pwallet.Account, params *pchannel.Params, nex
 }
 
 // BuildConclude returns an extrinsic that concludes a channel.
+// The registered state is not known here, callers check it with
+// ValidateConcludable.
 func (p *Pallet) BuildConclude(acc pwallet.Account, params *pchannel.Params) (*types.Extrinsic, error) {
 	_params, err := channel.NewParams(params)
 	if err != nil {
@@ -203,7 +413,11 @@ func (p *Pallet) BuildConclude(acc pwallet.Account, params *pchannel.Params) (*t
 }
 
 // BuildConcludeFinal returns an extrinsic that concludes a channel.
+// Returns an error if the pallet would reject it, see ValidateConcludeFinal.
 func (p *Pallet) BuildConcludeFinal(acc pwallet.Account, params *pchannel.Params, state *pchannel.State, sigs []pwallet.Sig) (*types.Extrinsic, error) {
+	if err := ValidateConcludeFinal(params, state, sigs); err != nil {
+		return nil, err
+	}
 	_params, err := channel.NewParams(params)
 	if err != nil {
 		return nil, err
@@ -212,7 +426,7 @@ func (p *Pallet) BuildConcludeFinal(acc pwallet.Account, params *pchannel.Params
 	if err != nil {
 		return nil, err
 	}
-	_sigs, err := channel.MakeSigs(sigs)
+	_sigs, err := makeSigs(sigs, len(_params.Participants))
 	if err != nil {
 		return nil, err
 	}
@@ -228,9 +442,17 @@ func (p *Pallet) BuildConcludeFinal(acc pwallet.Account, params *pchannel.Params
 }
 
 // BuildWithdraw returns an extrinsic that withdraws all funds from the channel.
+// The funds are paid out to `onChain`.
 func (p *Pallet) BuildWithdraw(onChain, offChain pwallet.Account, cid pchannel.ID) (*types.Extrinsic, error) {
+	return p.BuildWithdrawTo(onChain, offChain, cid, onChain.Address())
+}
+
+// BuildWithdrawTo returns an extrinsic that withdraws all funds from the
+// channel to `receiver`. The extrinsic is signed and paid by `onChain` while
+// `offChain` signs the withdrawal. Callers check that the channel is
+// concluded with ValidateWithdrawable.
+func (p *Pallet) BuildWithdrawTo(onChain, offChain pwallet.Account, cid pchannel.ID, receiver pwallet.Address) (*types.Extrinsic, error) {
 	part := offChain.Address()
-	receiver := onChain.Address()
 
 	withdrawal, err := channel.NewWithdrawal(cid, part, receiver)
 	if err != nil {
@@ -256,3 +478,12 @@ func (p *Pallet) BuildWithdraw(onChain, offChain pwallet.Account, cid pchannel.I
 		wallet.AsAddr(onChain.Address()).AccountID(),
 		wallet.AsAcc(onChain))
 }
+
+// makeSigs converts the signatures and checks that there is one per
+// participant.
+func makeSigs(sigs []pwallet.Sig, numParts int) ([]channel.Sig, error) {
+	if len(sigs) != numParts {
+		return nil, errors.Errorf("got %d signatures for %d participants", len(sigs), numParts)
+	}
+	return channel.MakeSigs(sigs)
+}
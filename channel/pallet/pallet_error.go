@@ -0,0 +1,166 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+)
+
+var (
+	// ErrInvalidSignature the pallet rejected a signature.
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrInvalidChannelID the state does not belong to the channel.
+	ErrInvalidChannelID = errors.New("invalid channel ID")
+	// ErrStateNotFinal a final state was expected.
+	ErrStateNotFinal = errors.New("state not final")
+	// ErrNotConcluded the channel is not concluded.
+	ErrNotConcluded = errors.New("channel not concluded")
+	// ErrTimeoutNotPassed the dispute timeout did not pass yet.
+	ErrTimeoutNotPassed = errors.New("dispute timeout not passed")
+	// ErrTimeoutPassed the dispute timeout already passed.
+	ErrTimeoutPassed = errors.New("dispute timeout passed")
+	// ErrInsufficientDeposits the channel is not fully funded.
+	ErrInsufficientDeposits = errors.New("insufficient deposits")
+	// ErrOverflow a numeric value overflowed in the pallet.
+	ErrOverflow = errors.New("numeric overflow")
+	// ErrAlreadyConcluded the channel is already concluded.
+	ErrAlreadyConcluded = errors.New("channel already concluded")
+)
+
+// palletErrors maps the names of the error variants of the Perun pallet to
+// Go errors. Variants that are not listed are returned as PalletError
+// without a mapped error.
+var palletErrors = map[string]error{
+	"Overflow":             ErrOverflow,
+	"UnknownDeposit":       ErrNoDeposit,
+	"InvalidSignature":     ErrInvalidSignature,
+	"InvalidChannelId":     ErrInvalidChannelID,
+	"StateNotFinal":        ErrStateNotFinal,
+	"DisputeVersionTooLow": ErrReqVersionTooLow,
+	"AlreadyConcluded":     ErrAlreadyConcluded,
+	"NotConcluded":         ErrNotConcluded,
+	"TimeoutNotPassed":     ErrTimeoutNotPassed,
+	"TimeoutPassed":        ErrTimeoutPassed,
+	"InsufficientDeposits": ErrInsufficientDeposits,
+	"UnknownChannel":       ErrNoRegisteredState,
+}
+
+// PalletError is an error that the Perun pallet returned for a call.
+// It matches substrate.ErrCallFailed and the Go error of its variant with
+// errors.Is.
+type PalletError struct {
+	// Name is the name of the error variant.
+	Name string
+	// Docs is the documentation of the error variant.
+	Docs string
+
+	mapped error
+}
+
+// Error returns the name and documentation of the error.
+func (e *PalletError) Error() string {
+	if e.Docs == "" {
+		return fmt.Sprintf("%v: %s", substrate.ErrCallFailed, e.Name)
+	}
+	return fmt.Sprintf("%v: %s: %s", substrate.ErrCallFailed, e.Name, e.Docs)
+}
+
+// Unwrap returns the Go error of the variant or substrate.ErrCallFailed if
+// it has none.
+func (e *PalletError) Unwrap() error {
+	if e.mapped == nil {
+		return substrate.ErrCallFailed
+	}
+	return e.mapped
+}
+
+// Is returns whether the target is substrate.ErrCallFailed.
+// The mapped error is matched by Unwrap.
+func (e *PalletError) Is(target error) bool {
+	return target == substrate.ErrCallFailed
+}
+
+// DecodeError converts a dispatch error into a PalletError if it was returned
+// by the Perun pallet. Other errors are decoded with substrate.DecodeError.
+func (p *Pallet) DecodeError(err types.DispatchError) error {
+	module, e, merr := substrate.ModuleError(p.meta, err)
+	if merr != nil || module != PerunPallet {
+		return substrate.DecodeError(p.meta, err)
+	}
+	return &PalletError{
+		Name:   string(e.Name),
+		Docs:   substrate.FormatErrorDocs(e),
+		mapped: palletErrors[string(e.Name)],
+	}
+}
+
+// waitResult waits until a submitted Extrinsic is finalized and returns the
+// error that its call returned, if any.
+func waitResult(ctx context.Context, p *Pallet, sub *substrate.ExtStatusSub, ext *types.Extrinsic) error {
+	var block types.Hash
+	if err := sub.WaitUntil(ctx, func(status *types.ExtrinsicStatus) bool {
+		if status.IsFinalized {
+			block = status.AsFinalized
+		}
+		return status.IsFinalized
+	}); err != nil {
+		return err
+	}
+	return p.callResult(block, ext)
+}
+
+// callResult returns the decoded error of an Extrinsic that was included in
+// a block, nil if its call succeeded or an error if the result cannot be
+// read.
+func (p *Pallet) callResult(block types.Hash, ext *types.Extrinsic) error {
+	dErr, err := p.dispatchError(block, ext)
+	if err != nil {
+		return err
+	}
+	if dErr != nil {
+		return p.DecodeError(*dErr)
+	}
+	return nil
+}
+
+// dispatchError returns the dispatch error of an Extrinsic that was included
+// in a block or nil if its call succeeded.
+func (p *Pallet) dispatchError(block types.Hash, ext *types.Extrinsic) (*types.DispatchError, error) {
+	index, err := p.ExtIndex(block, ext)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := p.EventsAt(block)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "reading events of block 0x%x", block)
+	}
+	records, err := channel.DecodeEventRecords(raw, p.meta)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "decoding events of block 0x%x", block)
+	}
+	for _, e := range records.System_ExtrinsicFailed {
+		if e.Phase.IsApplyExtrinsic && int(e.Phase.AsApplyExtrinsic) == index {
+			return &e.DispatchError, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet_test
+
+import (
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pchannel "perun.network/go-perun/channel"
+
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet"
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet/test"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+)
+
+func TestPallet_DecodeError(t *testing.T) {
+	meta := types.NewMetadataV13()
+	meta.AsMetadataV13.Modules = []types.ModuleMetadataV13{
+		{Name: "System", Index: 0, Errors: []types.ErrorMetadataV8{{Name: "InvalidSpecName"}}},
+		{Name: pallet.PerunPallet, Index: 8, Errors: []types.ErrorMetadataV8{
+			{Name: "InvalidSignature", Documentation: []types.Text{" A signature is invalid."}},
+			{Name: "SomethingNew"},
+		}},
+	}
+	p := pallet.NewPallet(nil, meta)
+
+	// Known variants match their Go error and ErrCallFailed.
+	err := p.DecodeError(types.DispatchError{HasModule: true, Module: 8, Error: 0})
+	assert.ErrorIs(t, err, pallet.ErrInvalidSignature)
+	assert.ErrorIs(t, err, substrate.ErrCallFailed)
+	var pErr *pallet.PalletError
+	require.ErrorAs(t, err, &pErr)
+	assert.Equal(t, "InvalidSignature", pErr.Name)
+	assert.Equal(t, "A signature is invalid.", pErr.Docs)
+
+	// Unknown variants only match ErrCallFailed.
+	err = p.DecodeError(types.DispatchError{HasModule: true, Module: 8, Error: 1})
+	assert.ErrorIs(t, err, substrate.ErrCallFailed)
+	require.ErrorAs(t, err, &pErr)
+	assert.Equal(t, "SomethingNew", pErr.Name)
+
+	// Errors of other modules are no PalletErrors.
+	err = p.DecodeError(types.DispatchError{HasModule: true, Module: 0, Error: 0})
+	assert.ErrorIs(t, err, substrate.ErrCallFailed)
+	assert.False(t, errors.As(err, &pErr))
+	err = p.DecodeError(types.DispatchError{HasModule: true, Module: 3, Error: 0})
+	assert.ErrorIs(t, err, substrate.ErrUnknownError)
+}
+
+// TestPallet_ErrorsInMetadata checks that every mapped error variant exists
+// in the metadata of the node.
+func TestPallet_ErrorsInMetadata(t *testing.T) {
+	s := test.NewSetup(t)
+	meta, ok := substrate.Meta(s.API.Metadata())
+	require.True(t, ok)
+	names := make(map[string]bool)
+	for _, module := range meta.Modules {
+		if string(module.Name) != pallet.PerunPallet {
+			continue
+		}
+		for _, e := range module.Errors {
+			names[string(e.Name)] = true
+		}
+	}
+	require.NotEmpty(t, names, "no errors of the Perun pallet in the metadata")
+	for name := range pallet.PalletErrors {
+		assert.True(t, names[name], "unknown error variant %s", name)
+	}
+}
+
+// TestAdjudicator_CallFailed checks that a rejected call returns a
+// PalletError instead of waiting for its event.
+func TestAdjudicator_CallFailed(t *testing.T) {
+	s := test.NewSetup(t)
+	adj := pallet.NewAdjudicator(s.Alice.Acc, s.Pallet, s.API, test.PastBlocks)
+	req := newUnfundedFinalReq(s)
+
+	err := adj.Withdraw(s.NewCtx(), req, nil)
+	assert.ErrorIs(t, err, substrate.ErrCallFailed)
+	var pErr *pallet.PalletError
+	assert.ErrorAs(t, err, &pErr)
+}
+
+// newUnfundedFinalReq returns a request for a final state of a channel that
+// was never funded. The pallet rejects concluding it, which is not checked
+// locally.
+func newUnfundedFinalReq(s *test.Setup) pchannel.AdjudicatorReq {
+	req, _, _ := newAdjReq(s, true)
+	return req
+}
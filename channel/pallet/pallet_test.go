@@ -0,0 +1,130 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet_test
+
+import (
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet"
+)
+
+func TestDetectHasher(t *testing.T) {
+	tests := []struct {
+		name    string
+		consts  []types.ModuleConstantMetadataV6
+		want    channel.Hasher
+		wantErr error
+	}{
+		{"default", nil, channel.DefaultHasher, nil},
+		{"keccak", []types.ModuleConstantMetadataV6{hasherConst(0)}, channel.Keccak256, nil},
+		{"blake2", []types.ModuleConstantMetadataV6{hasherConst(1)}, channel.Blake2b256, nil},
+		{"unknown", []types.ModuleConstantMetadataV6{hasherConst(7)}, 0, channel.ErrUnknownHasher},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := pallet.DetectHasher(newMetaWithConsts(tc.consts))
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestDetectTimeoutMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		consts  []types.ModuleConstantMetadataV6
+		want    channel.TimeoutMode
+		wantErr error
+	}{
+		{"default", nil, channel.DefaultTimeoutMode, nil},
+		{"other const", []types.ModuleConstantMetadataV6{hasherConst(1)}, channel.DefaultTimeoutMode, nil},
+		{"timestamp", []types.ModuleConstantMetadataV6{timeoutModeConst(0)}, channel.TimestampTimeout, nil},
+		{"block", []types.ModuleConstantMetadataV6{timeoutModeConst(1)}, channel.BlockTimeout, nil},
+		{"unknown", []types.ModuleConstantMetadataV6{timeoutModeConst(7)}, 0, channel.ErrUnknownTimeoutMode},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := pallet.DetectTimeoutMode(newMetaWithConsts(tc.consts))
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestConfigureBackend(t *testing.T) {
+	hasher, mode := channel.Backend.Hasher(), channel.Backend.TimeoutMode()
+	defer func() {
+		require.NoError(t, channel.Backend.SetHasher(hasher))
+		require.NoError(t, channel.Backend.SetTimeoutMode(mode))
+	}()
+	require.NoError(t, channel.Backend.SetHasher(channel.Keccak256))
+	require.NoError(t, channel.Backend.SetTimeoutMode(channel.TimestampTimeout))
+
+	// NewPallet leaves the Backend alone.
+	pallet.NewPallet(nil, newMetaWithConsts([]types.ModuleConstantMetadataV6{hasherConst(1)}))
+	assert.Equal(t, channel.Keccak256, channel.Backend.Hasher())
+
+	// Values that the pallet does not expose are kept.
+	require.NoError(t, pallet.ConfigureBackend(newMetaWithConsts(nil)))
+	assert.Equal(t, channel.Keccak256, channel.Backend.Hasher())
+
+	require.NoError(t, pallet.ConfigureBackend(newMetaWithConsts([]types.ModuleConstantMetadataV6{hasherConst(1), timeoutModeConst(1)})))
+	assert.Equal(t, channel.Blake2b256, channel.Backend.Hasher())
+	assert.Equal(t, channel.BlockTimeout, channel.Backend.TimeoutMode())
+
+	err := pallet.ConfigureBackend(newMetaWithConsts([]types.ModuleConstantMetadataV6{hasherConst(7)}))
+	assert.ErrorIs(t, err, channel.ErrUnknownHasher)
+
+	// Unreadable metadata is an error and not a missing constant.
+	assert.Error(t, pallet.ConfigureBackend(new(types.Metadata)))
+	_, err = pallet.DetectHasher(new(types.Metadata))
+	assert.Error(t, err)
+}
+
+func hasherConst(h uint8) types.ModuleConstantMetadataV6 {
+	return u8Const(pallet.HasherConst, h)
+}
+
+func timeoutModeConst(m uint8) types.ModuleConstantMetadataV6 {
+	return u8Const(pallet.TimeoutModeConst, m)
+}
+
+func u8Const(name string, v uint8) types.ModuleConstantMetadataV6 {
+	return types.ModuleConstantMetadataV6{Name: types.Text(name), Type: "u8", Value: []byte{v}}
+}
+
+// newMetaWithConsts returns metadata that contains only the Perun pallet
+// with the passed constants.
+func newMetaWithConsts(consts []types.ModuleConstantMetadataV6) *types.Metadata {
+	meta := types.NewMetadataV13()
+	meta.AsMetadataV13.Modules = []types.ModuleMetadataV13{{
+		Name:      pallet.PerunPallet,
+		Constants: consts,
+	}}
+	return meta
+}
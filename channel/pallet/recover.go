@@ -0,0 +1,94 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	pchannel "perun.network/go-perun/channel"
+	pwallet "perun.network/go-perun/wallet"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+)
+
+// ErrNotInitialState the state passed to RecoverFunding was not the initial
+// state of the channel.
+var ErrNotInitialState = errors.New("not the initial state")
+
+// RecoverFunding returns the deposit of a participant after the funding of a
+// channel failed, e.g. with a FundingTimeoutError.
+// It registers the initial state, waits for the dispute timeout, concludes
+// the channel and withdraws the deposit of `acc` to the receiver of the
+// channel, see SetReceiver. `state` must be the initial state with the
+// signatures of all participants and `acc` the off-chain account of a
+// participant.
+//
+// Every step is skipped if the chain shows that it was already done, which
+// makes it safe to re-run RecoverFunding after an error or crash. If another
+// state was registered in the meantime, the channel is concluded with that
+// state instead.
+func (a *Adjudicator) RecoverFunding(ctx context.Context, params *pchannel.Params, state pchannel.Transaction, acc pwallet.Account) error {
+	if state.State == nil || state.Version != 0 {
+		return ErrNotInitialState
+	}
+	cid := params.ID()
+	log := a.Log().WithField("cid", cid)
+
+	// Register the initial state if no state is registered yet.
+	dis, err := a.pallet.QueryStateRegister(cid, a.storage, a.pastBlocks)
+	if errors.Is(err, ErrNoRegisteredState) {
+		log.Debug("Recovery: registering initial state")
+		req := pchannel.AdjudicatorReq{Params: params, Acc: acc, Tx: state}
+		if err := a.Register(ctx, req, nil); err != nil {
+			return errors.WithMessage(err, "registering initial state")
+		}
+		dis, err = a.pallet.QueryStateRegister(cid, a.storage, a.pastBlocks)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Conclude the channel after the dispute timeout.
+	if dis.Phase != channel.ConcludePhase {
+		log.Debug("Recovery: concluding")
+		if err := a.waitConcludable(ctx, params, dis); err != nil {
+			return err
+		}
+		ext, err := a.pallet.BuildConclude(a.onChain, params)
+		if err != nil {
+			return err
+		}
+		if err := a.conclude(ctx, cid, ext); err != nil {
+			return errors.WithMessage(err, "concluding")
+		}
+	}
+
+	// Withdraw the deposit if there is one left.
+	fid, err := calcFid(cid, acc.Address())
+	if err != nil {
+		return err
+	}
+	dep, err := a.pallet.QueryDeposit(fid, a.storage, 0)
+	if errors.Is(err, ErrNoDeposit) || (err == nil && channel.MakePerunBalance(*dep).Sign() == 0) {
+		log.Debug("Recovery: nothing to withdraw")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	log.Debug("Recovery: withdrawing")
+	req := pchannel.AdjudicatorReq{Params: params, Acc: acc, Tx: state}
+	return errors.WithMessage(a.withdraw(ctx, req), "withdrawing")
+}
@@ -0,0 +1,187 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
+	pchannel "perun.network/go-perun/channel"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/perun-network/perun-polkadot-backend/wallet"
+)
+
+// RetryPolicy configures how the Adjudicator resubmits its Extrinsics when
+// they are dropped or invalidated before they are included. Every
+// resubmission is signed again with the next nonce of the account that is
+// free in the transaction pool, so that other pending Extrinsics of the
+// account are not replaced.
+type RetryPolicy struct {
+	// MaxAttempts is the maximal number of submissions of one operation.
+	// Values below 1 are treated as 1.
+	MaxAttempts int
+	// Backoff is the delay before the first resubmission. It doubles with
+	// every further attempt.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between two attempts. Zero means no cap.
+	MaxBackoff time.Duration
+	// TipStep is added to the tip with every resubmission to raise the
+	// priority of the Extrinsic. Nil means no tip.
+	TipStep *big.Int
+	// DeadlineMargin stops resubmitting disputes and progressions once the
+	// on-chain timeout of the channel is less than DeadlineMargin away. It is
+	// given in seconds or blocks, depending on the TimeoutMode of the pallet.
+	DeadlineMargin channel.ChallengeDuration
+}
+
+var (
+	// DefaultRetryPolicy submits every Extrinsic once.
+	DefaultRetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+	// ErrRetryDeadline the on-chain timeout of the channel is too close to
+	// resubmit an Extrinsic.
+	ErrRetryDeadline = errors.New("channel timeout too close to retry")
+)
+
+// WithRetryPolicy configures how the Adjudicator resubmits its Extrinsics.
+// Defaults to DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) AdjudicatorOpt {
+	return func(a *Adjudicator) {
+		a.retry = policy
+	}
+}
+
+// attempts returns the maximal number of submissions.
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// backoff returns the delay before attempt `attempt`, starting at 2.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 2; i < attempt; i++ {
+		delay *= 2
+		if p.MaxBackoff != 0 && delay >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff != 0 && delay > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return delay
+}
+
+// tip returns the tip of attempt `attempt`, starting at 1.
+func (p RetryPolicy) tip(attempt int) *big.Int {
+	if p.TipStep == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Mul(p.TipStep, big.NewInt(int64(attempt-1)))
+}
+
+// call sends an Extrinsic and waits for it to be finalized. It is submitted
+// again according to the RetryPolicy if it fails in a way that a
+// resubmission can fix. Every submission is dry run first if WithDryRun is
+// configured.
+func (a *Adjudicator) call(ctx context.Context, op OpKey, ext *types.Extrinsic) error {
+	for attempt := 1; ; attempt++ {
+		log := a.Log().WithField("op", op).WithField("attempt", attempt)
+		if attempt > 1 {
+			tip := a.retry.tip(attempt)
+			log = log.WithField("tip", tip)
+			var err error
+			if ext, err = a.pallet.Resign(ext, wallet.AsAddr(a.onChain.Address()).AccountID(), wallet.AsAcc(a.onChain), tip); err != nil {
+				return err
+			}
+		}
+		if err := a.checkCall(op, ext); err != nil {
+			return err
+		}
+		log.Debug("Submitting extrinsic")
+		err := transact(ctx, a.pallet, a.journal, op, ext)
+		if err == nil {
+			log.Debug("Extrinsic finalized")
+			return nil
+		}
+		if !retryable(err) || attempt >= a.retry.attempts() {
+			log.WithError(err).Warn("Extrinsic failed")
+			return err
+		}
+		log.WithError(err).Warn("Extrinsic failed, retrying")
+
+		select {
+		case <-time.After(a.retry.backoff(attempt + 1)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		deadline, derr := a.retryDeadline(op)
+		if derr != nil {
+			return derr
+		}
+		if deadline != nil && deadline.IsElapsed(ctx) {
+			log.Warn("Channel timeout too close, not retrying")
+			return errors.WithMessage(ErrRetryDeadline, err.Error())
+		}
+	}
+}
+
+// retryDeadline returns the timeout after which an operation must not be
+// resubmitted or nil if there is none. Only disputes and progressions of a
+// running dispute have a deadline.
+func (a *Adjudicator) retryDeadline(op OpKey) (pchannel.Timeout, error) {
+	if op.Kind != OpDispute && op.Kind != OpProgress {
+		return nil, nil
+	}
+	dis, err := a.pallet.QueryStateRegister(op.ID, a.storage, a.pastBlocks)
+	if errors.Is(err, ErrNoRegisteredState) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	timeout := dis.Timeout
+	if timeout > a.retry.DeadlineMargin {
+		timeout -= a.retry.DeadlineMargin
+	} else {
+		timeout = 0
+	}
+	return channel.MakeTimeout(timeout, a.storage), nil
+}
+
+// retryable returns whether an Extrinsic that failed with `err` can succeed
+// when it is signed and submitted again.
+func retryable(err error) bool {
+	if extFailed(err) {
+		return true
+	}
+	// The pool rejects Extrinsics whose nonce was used or collides with
+	// another Extrinsic of the account.
+	msg := err.Error()
+	return strings.Contains(msg, "Priority is too low") || strings.Contains(msg, "Transaction is outdated")
+}
+
+// extFailed returns whether an Extrinsic left the pool without being
+// included.
+func extFailed(err error) bool {
+	return errors.Is(err, substrate.ErrExtDropped) || errors.Is(err, substrate.ErrExtInvalid) || errors.Is(err, substrate.ErrExtUsurped)
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+		MaxBackoff:  3 * time.Second,
+		TipStep:     big.NewInt(10),
+	}
+	assert.Equal(t, 5, policy.attempts())
+	assert.Equal(t, 1, DefaultRetryPolicy.attempts())
+	assert.Equal(t, 1, RetryPolicy{}.attempts())
+
+	assert.Equal(t, time.Second, policy.backoff(2))
+	assert.Equal(t, 2*time.Second, policy.backoff(3))
+	assert.Equal(t, 3*time.Second, policy.backoff(4))
+	assert.Equal(t, 3*time.Second, policy.backoff(5))
+
+	assert.Zero(t, policy.tip(1).Sign())
+	assert.Equal(t, big.NewInt(30), policy.tip(4))
+	assert.Zero(t, RetryPolicy{}.tip(3).Sign())
+}
+
+func TestRetryable(t *testing.T) {
+	for _, err := range []error{
+		substrate.ErrExtDropped,
+		errors.WithMessage(substrate.ErrExtUsurped, "by 0x00"),
+		substrate.ErrExtInvalid,
+		errors.New("1014: Priority is too low: (0 vs 0)"),
+		errors.New("1010: Invalid Transaction: Transaction is outdated"),
+	} {
+		assert.True(t, retryable(err), err)
+	}
+	for _, err := range []error{
+		errors.New("1010: Invalid Transaction: Inability to pay some fees"),
+		ErrNoRegisteredState,
+	} {
+		assert.False(t, retryable(err), err)
+	}
+}
@@ -54,15 +54,16 @@ const (
 )
 
 // NewSetup returns a new Setup.
-func NewSetup(t *testing.T) *Setup {
+func NewSetup(t testing.TB) *Setup {
 	s := chtest.NewSetup(t)
+	require.NoError(t, pallet.ConfigureBackend(s.API.Metadata()))
 	p := pallet.NewPallet(pallet.NewPerunPallet(s.API), s.API.Metadata())
 	ret := &Setup{Setup: s, Pallet: p}
 
 	for i := 0; i < len(s.Accs); i++ {
 		dep := pallet.NewDepositor(p)
 		ret.Deps = append(ret.Deps, dep)
-		ret.Funders = append(ret.Funders, pallet.NewFunder(p, s.Accs[i].Acc, PastBlocks))
+		ret.Funders = append(ret.Funders, pallet.NewFunder(p, s.Accs[i].Acc, s.API, PastBlocks))
 		ret.Adjs = append(ret.Adjs, pallet.NewAdjudicator(s.Accs[i].Acc, p, s.API, PastBlocks))
 	}
 
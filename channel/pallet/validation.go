@@ -0,0 +1,199 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"github.com/pkg/errors"
+	pchannel "perun.network/go-perun/channel"
+	pwallet "perun.network/go-perun/wallet"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+)
+
+// The functions of this file mirror the rules that the Perun pallet applies
+// to its calls. They are checked before an Extrinsic is built, so that calls
+// which the pallet would reject are never submitted.
+
+var (
+	// ErrPartsMismatch the number of balances or signatures does not match
+	// the number of participants.
+	ErrPartsMismatch = errors.New("number of participants mismatch")
+	// ErrInvalidTransition the pallet would not progress a channel to a
+	// state.
+	ErrInvalidTransition = errors.New("invalid transition")
+)
+
+// ValidateState checks that a state belongs to the channel of the params and
+// has one balance per participant.
+func ValidateState(params *pchannel.Params, state *pchannel.State) error {
+	if state.ID != params.ID() {
+		return errors.WithMessagef(ErrInvalidChannelID, "state of channel 0x%x", state.ID)
+	}
+	for i, bals := range state.Balances {
+		if len(bals) != len(params.Parts) {
+			return errors.WithMessagef(ErrPartsMismatch, "%d balances of asset %d for %d participants", len(bals), i, len(params.Parts))
+		}
+	}
+	return nil
+}
+
+// ValidateSigs checks that every participant signed the state.
+func ValidateSigs(params *pchannel.Params, state *pchannel.State, sigs []pwallet.Sig) error {
+	if len(sigs) != len(params.Parts) {
+		return errors.WithMessagef(ErrPartsMismatch, "%d signatures for %d participants", len(sigs), len(params.Parts))
+	}
+	for i := range params.Parts {
+		if err := validateSig(params, state, sigs[i], pchannel.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateDispute checks that the pallet accepts a dispute with the state
+// and signatures. It does not check the registered state, see
+// ValidateRegisterVersion and ValidateRegisterable.
+func ValidateDispute(params *pchannel.Params, state *pchannel.State, sigs []pwallet.Sig) error {
+	if err := ValidateState(params, state); err != nil {
+		return err
+	}
+	return ValidateSigs(params, state, sigs)
+}
+
+// ValidateConcludeFinal checks that the pallet accepts a conclude_final call
+// with the state and signatures.
+func ValidateConcludeFinal(params *pchannel.Params, state *pchannel.State, sigs []pwallet.Sig) error {
+	if !state.IsFinal {
+		return ErrStateNotFinal
+	}
+	return ValidateDispute(params, state, sigs)
+}
+
+// ValidateRegisterVersion checks that a state of the given version can be
+// registered on top of the registered state. Registering the same version
+// again is accepted.
+func ValidateRegisterVersion(reg *channel.RegisteredState, version channel.Version) error {
+	if version < reg.State.Version {
+		return errors.WithMessagef(ErrReqVersionTooLow, "got %v, expected %v", version, reg.State.Version)
+	}
+	return nil
+}
+
+// ValidateRegisterable checks that a state can be registered on top of the
+// registered state at chain time `now`, which is given in seconds or blocks
+// depending on the TimeoutMode. The registered state must be in the register
+// phase and its timeout must not have passed.
+func ValidateRegisterable(reg *channel.RegisteredState, now channel.ChallengeDuration) error {
+	switch {
+	case reg.Phase == channel.ConcludePhase:
+		return ErrAlreadyConcluded
+	case reg.Phase != channel.RegisterPhase:
+		return errors.WithMessagef(ErrTimeoutPassed, "channel in phase %d", reg.Phase)
+	case now >= reg.Timeout:
+		return errors.WithMessagef(ErrTimeoutPassed, "timeout %d passed at %d", reg.Timeout, now)
+	}
+	return nil
+}
+
+// ValidateConcludable checks that the registered state can be concluded at
+// chain time `now`, which is given in seconds or blocks depending on the
+// TimeoutMode. The timeout and the progression `window` after it must have
+// passed. The window is zero for channels without app, see progressWindow.
+func ValidateConcludable(reg *channel.RegisteredState, now, window channel.ChallengeDuration) error {
+	switch {
+	case reg.Phase == channel.ConcludePhase:
+		return ErrAlreadyConcluded
+	case now < reg.Timeout+window:
+		return errors.WithMessagef(ErrTimeoutNotPassed, "concludable at %d, now %d", reg.Timeout+window, now)
+	}
+	return nil
+}
+
+// ValidateWithdrawable checks that funds can be withdrawn from a channel with
+// the registered state, which requires it to be concluded.
+func ValidateWithdrawable(reg *channel.RegisteredState) error {
+	if reg.Phase != channel.ConcludePhase {
+		return errors.WithMessagef(ErrNotConcluded, "channel in phase %d", reg.Phase)
+	}
+	return nil
+}
+
+// progressWindow returns the time after the dispute timeout in which a
+// channel can still be progressed. It is zero for channels without app.
+func progressWindow(params *pchannel.Params) channel.ChallengeDuration {
+	if pchannel.IsNoApp(params.App) {
+		return 0
+	}
+	return params.ChallengeDuration
+}
+
+// ValidateProgress checks that the pallet accepts a progression from the
+// registered state `from` to `to`, which is signed by participant `signer`.
+func ValidateProgress(params *pchannel.Params, from, to *pchannel.State, sig pwallet.Sig, signer pchannel.Index) error {
+	if err := ValidateState(params, to); err != nil {
+		return err
+	}
+	if err := validateSig(params, to, sig, signer); err != nil {
+		return err
+	}
+	return ValidateTransition(params, from, to, signer)
+}
+
+// ValidateTransition checks that `to` is a valid successor of `from`: The
+// version must increase by one, the balances must be conserved and the app
+// must accept the transition by participant `actor`.
+func ValidateTransition(params *pchannel.Params, from, to *pchannel.State, actor pchannel.Index) error {
+	switch {
+	case from.ID != to.ID:
+		return errors.WithMessage(ErrInvalidChannelID, "progressing to another channel")
+	case from.IsFinal:
+		return errors.WithMessage(ErrInvalidTransition, "progressing from a final state")
+	case to.Version != from.Version+1:
+		return errors.WithMessagef(ErrInvalidTransition, "version %d does not follow %d", to.Version, from.Version)
+	}
+	if err := pchannel.AssertAssetsEqual(from.Assets, to.Assets); err != nil {
+		return errors.WithMessage(ErrInvalidTransition, err.Error())
+	}
+	fromSum, toSum := from.Sum(), to.Sum()
+	for i := range fromSum {
+		if fromSum[i].Cmp(toSum[i]) != 0 {
+			return errors.WithMessagef(ErrInvalidTransition, "balances of asset %d not conserved: %v != %v", i, toSum[i], fromSum[i])
+		}
+	}
+	if int(actor) >= len(params.Parts) {
+		return errors.WithMessagef(ErrPartsMismatch, "actor index %d out of range", actor)
+	}
+	app, ok := params.App.(pchannel.StateApp)
+	if !ok {
+		return errors.WithMessage(ErrInvalidTransition, "app does not support progression")
+	}
+	if err := app.ValidTransition(params, from, to, actor); err != nil {
+		return errors.WithMessage(ErrInvalidTransition, err.Error())
+	}
+	return nil
+}
+
+// validateSig checks that participant `idx` signed the state.
+func validateSig(params *pchannel.Params, state *pchannel.State, sig pwallet.Sig, idx pchannel.Index) error {
+	if int(idx) >= len(params.Parts) {
+		return errors.WithMessagef(ErrPartsMismatch, "signer index %d out of range", idx)
+	}
+	if ok, err := pchannel.Verify(params.Parts[idx], state, sig); err != nil {
+		return errors.WithMessagef(err, "verifying signature[%d]", idx)
+	} else if !ok {
+		return errors.WithMessagef(ErrInvalidSignature, "signature[%d]", idx)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pchannel "perun.network/go-perun/channel"
+	pwallet "perun.network/go-perun/wallet"
+	pkgtest "polycry.pt/poly-go/test"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet"
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet/test"
+	wallettest "github.com/perun-network/perun-polkadot-backend/wallet/sr25519/test"
+)
+
+func TestValidateDispute(t *testing.T) {
+	params, state, accs := newValidationChannel(t, pchannel.NoApp(), pchannel.NoData())
+	sigs := signAll(t, state, accs)
+	require.NoError(t, pallet.ValidateDispute(params, state, sigs))
+
+	// Swapped signatures.
+	err := pallet.ValidateDispute(params, state, []pwallet.Sig{sigs[1], sigs[0]})
+	assert.ErrorIs(t, err, pallet.ErrInvalidSignature)
+	// Missing signature.
+	assert.ErrorIs(t, pallet.ValidateDispute(params, state, sigs[:1]), pallet.ErrPartsMismatch)
+	// Wrong channel.
+	other := state.Clone()
+	other.ID[0]++
+	assert.ErrorIs(t, pallet.ValidateDispute(params, other, signAll(t, other, accs)), pallet.ErrInvalidChannelID)
+	// Missing balance.
+	other = state.Clone()
+	other.Balances[0] = other.Balances[0][:1]
+	assert.ErrorIs(t, pallet.ValidateDispute(params, other, sigs), pallet.ErrPartsMismatch)
+
+	// Only final states can be concluded with conclude_final.
+	assert.ErrorIs(t, pallet.ValidateConcludeFinal(params, state, sigs), pallet.ErrStateNotFinal)
+	final := state.Clone()
+	final.IsFinal = true
+	assert.NoError(t, pallet.ValidateConcludeFinal(params, final, signAll(t, final, accs)))
+}
+
+func TestValidateRegisterVersion(t *testing.T) {
+	reg := &channel.RegisteredState{State: channel.State{Version: 5}}
+	assert.ErrorIs(t, pallet.ValidateRegisterVersion(reg, 4), pallet.ErrReqVersionTooLow)
+	assert.NoError(t, pallet.ValidateRegisterVersion(reg, 5))
+	assert.NoError(t, pallet.ValidateRegisterVersion(reg, 6))
+}
+
+func TestValidateRegisterable(t *testing.T) {
+	reg := &channel.RegisteredState{Phase: channel.RegisterPhase, Timeout: 10}
+	assert.NoError(t, pallet.ValidateRegisterable(reg, 9))
+	assert.ErrorIs(t, pallet.ValidateRegisterable(reg, 10), pallet.ErrTimeoutPassed)
+
+	reg.Phase = channel.ProgressPhase
+	assert.ErrorIs(t, pallet.ValidateRegisterable(reg, 0), pallet.ErrTimeoutPassed)
+	reg.Phase = channel.ConcludePhase
+	assert.ErrorIs(t, pallet.ValidateRegisterable(reg, 0), pallet.ErrAlreadyConcluded)
+}
+
+func TestValidateConcludable(t *testing.T) {
+	reg := &channel.RegisteredState{Phase: channel.RegisterPhase, Timeout: 10}
+	assert.ErrorIs(t, pallet.ValidateConcludable(reg, 9, 0), pallet.ErrTimeoutNotPassed)
+	assert.NoError(t, pallet.ValidateConcludable(reg, 10, 0))
+	// App channels can be progressed for another window.
+	assert.ErrorIs(t, pallet.ValidateConcludable(reg, 14, 5), pallet.ErrTimeoutNotPassed)
+	assert.NoError(t, pallet.ValidateConcludable(reg, 15, 5))
+
+	reg.Phase = channel.ConcludePhase
+	assert.ErrorIs(t, pallet.ValidateConcludable(reg, 20, 0), pallet.ErrAlreadyConcluded)
+}
+
+func TestValidateWithdrawable(t *testing.T) {
+	reg := &channel.RegisteredState{Phase: channel.ConcludePhase}
+	assert.NoError(t, pallet.ValidateWithdrawable(reg))
+	for _, phase := range []channel.DisputePhase{channel.RegisterPhase, channel.ProgressPhase} {
+		reg.Phase = phase
+		assert.ErrorIs(t, pallet.ValidateWithdrawable(reg), pallet.ErrNotConcluded)
+	}
+}
+
+func TestValidateProgress(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	app := pchannel.NewMockApp(wallettest.NewWallet().NewRandomAccount(rng).Address())
+	params, from, accs := newValidationChannel(t, app, pchannel.NewMockOp(pchannel.OpValid))
+	next := func(f func(*pchannel.State)) (*pchannel.State, pwallet.Sig) {
+		to := from.Clone()
+		to.Version++
+		to.Balances[0][0].Sub(to.Balances[0][0], big.NewInt(1))
+		to.Balances[0][1].Add(to.Balances[0][1], big.NewInt(1))
+		f(to)
+		sig, err := pchannel.Sign(accs[1], to)
+		require.NoError(t, err)
+		return to, sig
+	}
+
+	to, sig := next(func(*pchannel.State) {})
+	require.NoError(t, pallet.ValidateProgress(params, from, to, sig, 1))
+	assert.ErrorIs(t, pallet.ValidateProgress(params, from, to, sig, 0), pallet.ErrInvalidSignature)
+	assert.ErrorIs(t, pallet.ValidateProgress(params, from, to, sig, 2), pallet.ErrPartsMismatch)
+
+	tests := []struct {
+		name string
+		f    func(*pchannel.State)
+	}{
+		{"version skipped", func(s *pchannel.State) { s.Version++ }},
+		{"balances not conserved", func(s *pchannel.State) { s.Balances[0][0].Add(s.Balances[0][0], big.NewInt(1)) }},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			to, sig := next(tc.f)
+			assert.ErrorIs(t, pallet.ValidateProgress(params, from, to, sig, 1), pallet.ErrInvalidTransition)
+		})
+	}
+
+	to, _ = next(func(*pchannel.State) {})
+	final := from.Clone()
+	final.IsFinal = true
+	assert.ErrorIs(t, pallet.ValidateTransition(params, final, to, 1), pallet.ErrInvalidTransition)
+	// The MockApp decides by the data of the old state.
+	rejecting := from.Clone()
+	rejecting.Data = pchannel.NewMockOp(pchannel.OpTransitionErr)
+	assert.ErrorIs(t, pallet.ValidateTransition(params, rejecting, to, 1), pallet.ErrInvalidTransition)
+}
+
+// TestAdjudicator_InvalidRegister checks that an invalid dispute is not
+// submitted.
+func TestAdjudicator_InvalidRegister(t *testing.T) {
+	s := test.NewSetup(t)
+	adj := pallet.NewAdjudicator(s.Alice.Acc, s.Pallet, s.API, test.PastBlocks)
+	req, _, _ := newAdjReq(s, false)
+	// Swap the signatures.
+	req.Tx.Sigs[0], req.Tx.Sigs[1] = req.Tx.Sigs[1], req.Tx.Sigs[0]
+
+	assert.ErrorIs(t, adj.Register(s.NewCtx(), req, nil), pallet.ErrInvalidSignature)
+	s.AssertNoRegistered(req.Params.ID())
+}
+
+// TestAdjudicator_RegisterAfterTimeout checks that a dispute is not
+// submitted after the timeout of the registered state passed.
+func TestAdjudicator_RegisterAfterTimeout(t *testing.T) {
+	s := test.NewSetup(t)
+	adj := pallet.NewAdjudicator(s.Alice.Acc, s.Pallet, s.API, test.PastBlocks)
+	req, params, _ := newInitialAdjReq(s, 1)
+	ctx := s.NewCtx()
+	require.NoError(t, adj.Register(ctx, req, nil))
+	dis, err := s.Pallet.QueryStateRegister(params.ID(), s.API, 0)
+	require.NoError(t, err)
+	require.NoError(t, channel.MakeTimeout(dis.Timeout, s.API).Wait(ctx))
+
+	req.Tx = nextVersion(s, req.Tx)
+	assert.ErrorIs(t, adj.Register(ctx, req, nil), pallet.ErrTimeoutPassed)
+	s.AssertRegistered(&dis.State, false)
+}
+
+// newValidationChannel returns a channel of two random participants and its
+// state with version 1.
+func newValidationChannel(t *testing.T, app pchannel.App, data pchannel.Data) (*pchannel.Params, *pchannel.State, []pwallet.Account) {
+	rng := pkgtest.Prng(t)
+	w := wallettest.NewWallet()
+	accs := []pwallet.Account{w.NewRandomAccount(rng), w.NewRandomAccount(rng)}
+	params, err := pchannel.NewParams(60, []pwallet.Address{accs[0].Address(), accs[1].Address()}, app, big.NewInt(1), true, false)
+	require.NoError(t, err)
+	state := &pchannel.State{
+		ID:         params.ID(),
+		Version:    1,
+		App:        app,
+		Allocation: pchannel.Allocation{Assets: []pchannel.Asset{channel.Asset}, Balances: pchannel.Balances{{big.NewInt(10), big.NewInt(20)}}},
+		Data:       data,
+	}
+	return params, state, accs
+}
+
+// signAll returns the signatures of all accounts on the state.
+func signAll(t *testing.T, state *pchannel.State, accs []pwallet.Account) []pwallet.Sig {
+	sigs := make([]pwallet.Sig, len(accs))
+	for i, acc := range accs {
+		var err error
+		sigs[i], err = pchannel.Sign(acc, state)
+		require.NoError(t, err)
+	}
+	return sigs
+}
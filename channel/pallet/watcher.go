@@ -0,0 +1,490 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	pchannel "perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/watcher"
+	pkgsync "polycry.pt/poly-go/sync"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+)
+
+var _ watcher.Watcher = (*Watcher)(nil)
+
+// WatcherBufferSize is the number of events that the event stream of a
+// watched channel buffers. Further events are queued until the client reads
+// them, so that a slow client does not delay other channels.
+const WatcherBufferSize = 16
+
+// refuteInterval is the delay between two attempts to refute a dispute.
+const refuteInterval = time.Second
+
+var (
+	// ErrAlreadyWatching the channel is already watched.
+	ErrAlreadyWatching = errors.New("already watching this channel")
+	// ErrNotWatching the channel is not watched.
+	ErrNotWatching = errors.New("not watching this channel")
+	// ErrSubChannelsUnsupported sub-channels are not supported.
+	ErrSubChannelsUnsupported = errors.New("sub-channels unsupported")
+)
+
+type (
+	// Watcher watches many channels with one event subscription. It
+	// implements the go-perun watcher interface and refutes disputes of
+	// outdated states by registering the newest state that the client
+	// published. The refutations are sent and paid by the Adjudicator.
+	Watcher struct {
+		*pkgsync.Closer
+		log.Embedding
+
+		adj *Adjudicator
+		sub *EventSub
+
+		mtx sync.Mutex
+		chs map[pchannel.ID]*watchedChannel
+	}
+
+	// watchedChannel is a channel that is watched by the Watcher. It
+	// implements the StatesPub and AdjudicatorSub of the channel.
+	// The events from the chain are processed by the handler, which refutes
+	// disputes, and delivered to the client by another goroutine.
+	watchedChannel struct {
+		log.Embedding
+
+		id       pchannel.ID
+		params   *pchannel.Params
+		ctx      context.Context
+		cancel   context.CancelFunc
+		received chan struct{} // Signals new events in fromChain.
+		queued   chan struct{} // Signals new events in toDeliver.
+		toClient chan pchannel.AdjudicatorEvent
+		stopped  chan struct{}
+
+		mtx       sync.Mutex
+		latest    pchannel.Transaction
+		err       error
+		fromChain []BlockEvent
+		toDeliver []pchannel.AdjudicatorEvent
+
+		// The following fields are only accessed by the handler.
+		refuted          bool
+		refutedVersion   uint64
+		published        bool
+		publishedVersion uint64
+		concluded        bool
+	}
+)
+
+// NewWatcher returns a new Watcher that uses the Adjudicator to refute
+// disputes. It subscribes to the events of all channels once.
+func NewWatcher(adj *Adjudicator) (*Watcher, error) {
+	sub, err := adj.pallet.Subscribe(isAnyAdjEvent, 0)
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		Closer:    new(pkgsync.Closer),
+		Embedding: log.MakeEmbedding(log.Default()),
+		adj:       adj,
+		sub:       sub,
+		chs:       make(map[pchannel.ID]*watchedChannel),
+	}
+	w.OnCloseAlways(func() {
+		if err := sub.Close(); err != nil && !pkgsync.IsAlreadyClosedError(err) {
+			w.Log().WithError(err).Error("Could not close EventSub.")
+		}
+		w.mtx.Lock()
+		defer w.mtx.Unlock()
+		for id, ch := range w.chs {
+			ch.cancel()
+			delete(w.chs, id)
+		}
+	})
+	go w.dispatch()
+	return w, nil
+}
+
+// StartWatchingLedgerChannel starts watching a channel. `signed` must be the
+// latest state of the channel, newer states are published with the returned
+// StatesPub. Disputes that were registered before are picked up.
+func (w *Watcher) StartWatchingLedgerChannel(_ context.Context, signed pchannel.SignedState) (watcher.StatesPub, watcher.AdjudicatorSub, error) {
+	if w.IsClosed() {
+		return nil, nil, errors.New("watcher closed")
+	}
+	id := signed.State.ID
+	ch := newWatchedChannel(signed)
+
+	w.mtx.Lock()
+	if _, ok := w.chs[id]; ok {
+		w.mtx.Unlock()
+		return nil, nil, ErrAlreadyWatching
+	}
+	w.chs[id] = ch
+	w.mtx.Unlock()
+	go ch.handle(w)
+
+	// Events of the channel are now received, check for older disputes. They
+	// are reported with the latest registered state.
+	dis, err := w.adj.pallet.QueryStateRegister(id, w.adj.storage, 0)
+	if errors.Is(err, ErrNoRegisteredState) {
+		return ch, ch, nil
+	} else if err != nil {
+		w.stop(id) // nolint: errcheck
+		return nil, nil, err
+	}
+	ch.push(BlockEvent{Event: &channel.DisputedEvent{Cid: id, State: dis.State}})
+	if dis.Phase == channel.ConcludePhase {
+		ch.push(BlockEvent{Event: &channel.ConcludedEvent{Cid: id}})
+	}
+	return ch, ch, nil
+}
+
+// StartWatchingSubChannel returns ErrSubChannelsUnsupported.
+func (w *Watcher) StartWatchingSubChannel(context.Context, pchannel.ID, pchannel.SignedState) (watcher.StatesPub, watcher.AdjudicatorSub, error) {
+	return nil, nil, ErrSubChannelsUnsupported
+}
+
+// StopWatching stops watching a channel and closes its event stream.
+func (w *Watcher) StopWatching(ctx context.Context, id pchannel.ID) error {
+	ch, err := w.stop(id)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ch.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop removes a channel from the Watcher and stops its handler.
+func (w *Watcher) stop(id pchannel.ID) (*watchedChannel, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	ch, ok := w.chs[id]
+	if !ok {
+		return nil, ErrNotWatching
+	}
+	delete(w.chs, id)
+	ch.cancel()
+	return ch, nil
+}
+
+// dispatch forwards the events of the subscription to the watched channels.
+func (w *Watcher) dispatch() {
+	for {
+		select {
+		case event := <-w.sub.BlockEvents():
+			w.mtx.Lock()
+			ch, ok := w.chs[eventCid(event.Event)]
+			w.mtx.Unlock()
+			if ok {
+				ch.push(event)
+			}
+		case err := <-w.sub.Err():
+			if err != nil {
+				w.Log().WithError(err).Error("Watcher subscription failed")
+				w.mtx.Lock()
+				for _, ch := range w.chs {
+					ch.fail(err)
+				}
+				w.mtx.Unlock()
+			}
+			if err := w.Close(); err != nil && !pkgsync.IsAlreadyClosedError(err) {
+				w.Log().WithError(err).Error("Could not close Watcher.")
+			}
+			return
+		case <-w.Closed():
+			return
+		}
+	}
+}
+
+// newWatchedChannel returns a new watchedChannel with `signed` as latest
+// state.
+func newWatchedChannel(signed pchannel.SignedState) *watchedChannel {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &watchedChannel{
+		Embedding: log.MakeEmbedding(log.WithField("cid", signed.State.ID)),
+		id:        signed.State.ID,
+		params:    signed.Params,
+		ctx:       ctx,
+		cancel:    cancel,
+		received:  make(chan struct{}, 1),
+		queued:    make(chan struct{}, 1),
+		toClient:  make(chan pchannel.AdjudicatorEvent, WatcherBufferSize),
+		stopped:   make(chan struct{}),
+		latest:    pchannel.Transaction{State: signed.State, Sigs: signed.Sigs},
+	}
+}
+
+// Publish sets the latest state of the channel. Implements
+// watcher.StatesPub.
+func (ch *watchedChannel) Publish(_ context.Context, tx pchannel.Transaction) error {
+	ch.mtx.Lock()
+	defer ch.mtx.Unlock()
+
+	if ch.ctx.Err() != nil {
+		return ErrNotWatching
+	}
+	ch.latest = tx
+	return nil
+}
+
+// EventStream returns the adjudicator events of the channel. It is closed
+// when the channel is not watched anymore. Implements
+// watcher.AdjudicatorSub.
+func (ch *watchedChannel) EventStream() <-chan pchannel.AdjudicatorEvent {
+	return ch.toClient
+}
+
+// Err returns the error that stopped the watching, if any. Implements
+// watcher.AdjudicatorSub.
+func (ch *watchedChannel) Err() error {
+	ch.mtx.Lock()
+	defer ch.mtx.Unlock()
+	return ch.err
+}
+
+// push queues an event from the chain for the handler. It does not block.
+func (ch *watchedChannel) push(event BlockEvent) {
+	ch.mtx.Lock()
+	defer ch.mtx.Unlock()
+	ch.fromChain = append(ch.fromChain, event)
+	signal(ch.received)
+}
+
+// queue queues an event for the client. It does not block.
+func (ch *watchedChannel) queue(event pchannel.AdjudicatorEvent) {
+	ch.mtx.Lock()
+	defer ch.mtx.Unlock()
+	ch.toDeliver = append(ch.toDeliver, event)
+	signal(ch.queued)
+}
+
+// takeFromChain returns and clears the queued events from the chain.
+func (ch *watchedChannel) takeFromChain() (events []BlockEvent) {
+	ch.mtx.Lock()
+	defer ch.mtx.Unlock()
+	events, ch.fromChain = ch.fromChain, nil
+	return
+}
+
+// takeToDeliver returns and clears the queued events for the client.
+func (ch *watchedChannel) takeToDeliver() (events []pchannel.AdjudicatorEvent) {
+	ch.mtx.Lock()
+	defer ch.mtx.Unlock()
+	events, ch.toDeliver = ch.toDeliver, nil
+	return
+}
+
+// signal wakes up the receiver of a notification channel with capacity one
+// without blocking.
+func signal(notify chan<- struct{}) {
+	select {
+	case notify <- struct{}{}:
+	default:
+	}
+}
+
+// fail stops the watching with an error.
+func (ch *watchedChannel) fail(err error) {
+	ch.mtx.Lock()
+	defer ch.mtx.Unlock()
+	ch.err = err
+	ch.cancel()
+}
+
+// latestTx returns the latest published state.
+func (ch *watchedChannel) latestTx() pchannel.Transaction {
+	ch.mtx.Lock()
+	defer ch.mtx.Unlock()
+	return ch.latest
+}
+
+// handle processes the events from the chain until the channel is not
+// watched anymore.
+func (ch *watchedChannel) handle(w *Watcher) {
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		ch.deliver()
+	}()
+	defer func() {
+		<-delivered
+		close(ch.stopped)
+	}()
+
+	for {
+		events := ch.takeFromChain()
+		for _, event := range events {
+			ch.handleEvent(w, event)
+		}
+		select {
+		case <-ch.received:
+		case <-ch.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver sends the queued events to the client until the channel is not
+// watched anymore. Closes the event stream when done.
+func (ch *watchedChannel) deliver() {
+	defer close(ch.toClient)
+
+	for {
+		for _, event := range ch.takeToDeliver() {
+			select {
+			case ch.toClient <- event:
+			case <-ch.ctx.Done():
+				return
+			}
+		}
+		select {
+		case <-ch.queued:
+		case <-ch.ctx.Done():
+			return
+		}
+	}
+}
+
+// handleEvent queues new events for the client and refutes outdated
+// disputes. The event is queued first, so that the client learns about a
+// dispute while it is refuted.
+func (ch *watchedChannel) handleEvent(w *Watcher, e BlockEvent) {
+	if ch.isNew(e.Event) {
+		adjEvent, err := makeAdjEvent(w.adj.pallet, w.adj.storage, e, ch.params.App)
+		if err != nil {
+			ch.Log().WithError(err).Errorf("Could not convert %T", e.Event)
+		} else {
+			ch.published, ch.publishedVersion = true, adjEvent.Version()
+			ch.queue(adjEvent)
+		}
+	}
+	if event, ok := e.Event.(*channel.DisputedEvent); ok {
+		ch.refute(w, event.State.Version)
+	}
+}
+
+// isNew returns whether an event was not reported to the client yet.
+func (ch *watchedChannel) isNew(e channel.PerunEvent) bool {
+	switch event := e.(type) {
+	case *channel.DisputedEvent:
+		return !ch.published || event.State.Version > ch.publishedVersion
+	case *channel.ProgressedEvent:
+		return !ch.published || event.Version > ch.publishedVersion
+	case *channel.ConcludedEvent:
+		if ch.concluded {
+			return false
+		}
+		ch.concluded = true
+	}
+	return true
+}
+
+// refute registers the latest state if the disputed version is older and
+// the latest state was not registered before. A final latest state is
+// concluded directly. Failed attempts are retried until the dispute can no
+// longer be refuted, then the watching fails with the error.
+func (ch *watchedChannel) refute(w *Watcher, disputed uint64) {
+	for attempt := 1; ; attempt++ {
+		latest := ch.latestTx()
+		if disputed >= latest.Version || (ch.refuted && ch.refutedVersion >= latest.Version) {
+			return
+		}
+		log := ch.Log().WithField("disputed", disputed).WithField("latest", latest.Version).WithField("attempt", attempt)
+		log.Info("Refuting outdated dispute")
+
+		err := ch.register(w, latest)
+		if err == nil {
+			ch.refuted, ch.refutedVersion = true, latest.Version
+			return
+		} else if ch.ctx.Err() != nil {
+			return
+		}
+		dis, qerr := w.adj.pallet.QueryStateRegister(ch.id, w.adj.storage, 0)
+		if qerr != nil {
+			log.WithError(qerr).Error("Could not query dispute")
+		} else if dis.State.Version >= latest.Version {
+			// The latest state was registered in the meantime.
+			ch.refuted, ch.refutedVersion = true, latest.Version
+			return
+		} else if !channel.MakeTimeout(dis.Timeout, w.adj.storage).IsElapsed(ch.ctx) {
+			log.WithError(err).Warn("Could not refute dispute, retrying")
+			select {
+			case <-time.After(refuteInterval):
+				continue
+			case <-ch.ctx.Done():
+				return
+			}
+		}
+		log.WithError(err).Error("Could not refute dispute")
+		ch.fail(errors.WithMessagef(err, "refuting dispute of version %d", disputed))
+		return
+	}
+}
+
+// register registers a state or concludes it directly if it is final.
+func (ch *watchedChannel) register(w *Watcher, tx pchannel.Transaction) error {
+	if tx.IsFinal {
+		return ch.concludeFinal(w, tx)
+	}
+	req := pchannel.AdjudicatorReq{Params: ch.params, Tx: tx}
+	return w.adj.Register(ch.ctx, req, nil)
+}
+
+// concludeFinal concludes the channel with a final state.
+func (ch *watchedChannel) concludeFinal(w *Watcher, tx pchannel.Transaction) error {
+	ext, err := w.adj.pallet.BuildConcludeFinal(w.adj.onChain, ch.params, tx.State, tx.Sigs)
+	if err != nil {
+		return err
+	}
+	return w.adj.conclude(ch.ctx, ch.id, ext)
+}
+
+// isAnyAdjEvent returns whether an event is relevant for the adjudicator
+// of any channel.
+func isAnyAdjEvent(e channel.PerunEvent) bool {
+	switch e.(type) {
+	case *channel.DisputedEvent, *channel.ProgressedEvent, *channel.ConcludedEvent:
+		return true
+	default:
+		return false
+	}
+}
+
+// eventCid returns the channel ID of an adjudicator event.
+func eventCid(e channel.PerunEvent) pchannel.ID {
+	switch e := e.(type) {
+	case *channel.DisputedEvent:
+		return e.Cid
+	case *channel.ProgressedEvent:
+		return e.Cid
+	case *channel.ConcludedEvent:
+		return e.Cid
+	default:
+		return pchannel.ID{}
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pallet_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pchannel "perun.network/go-perun/channel"
+	pwallet "perun.network/go-perun/wallet"
+	ctxtest "polycry.pt/poly-go/context/test"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet"
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet/test"
+)
+
+// TestWatcher_Refute checks that the Watcher refutes a dispute of an old
+// state and only reports events of watched channels.
+func TestWatcher_Refute(t *testing.T) {
+	s := test.NewSetup(t)
+	ctx := s.NewCtx()
+	adjAlice := pallet.NewAdjudicator(s.Alice.Acc, s.Pallet, s.API, test.PastBlocks)
+	adjBob := pallet.NewAdjudicator(s.Bob.Acc, s.Pallet, s.API, test.PastBlocks)
+	w, err := pallet.NewWatcher(adjAlice)
+	require.NoError(t, err)
+	defer w.Close()
+
+	// Alice watches two channels with version 1.
+	stale, params, _ := newInitialAdjReq(s, 60)
+	latest := nextVersion(s, stale.Tx)
+	_, sub, err := w.StartWatchingLedgerChannel(ctx, pchannel.SignedState{Params: params, State: latest.State, Sigs: latest.Sigs})
+	require.NoError(t, err)
+	other, otherParams, _ := newInitialAdjReq(s, 60)
+	otherLatest := nextVersion(s, other.Tx)
+	_, otherSub, err := w.StartWatchingLedgerChannel(ctx, pchannel.SignedState{Params: otherParams, State: otherLatest.State, Sigs: otherLatest.Sigs})
+	require.NoError(t, err)
+	_, _, err = w.StartWatchingLedgerChannel(ctx, pchannel.SignedState{Params: params, State: latest.State, Sigs: latest.Sigs})
+	assert.ErrorIs(t, err, pallet.ErrAlreadyWatching)
+
+	// Bob registers version 0 of the first channel.
+	stale.Acc, stale.Idx = s.Bob.Acc, 1
+	require.NoError(t, adjBob.Register(ctx, stale, nil))
+	// Alice sees the dispute and refutes it with version 1.
+	for _, version := range []uint64{0, 1} {
+		event := <-sub.EventStream()
+		require.IsType(t, &pchannel.RegisteredEvent{}, event)
+		assert.Equal(t, params.ID(), event.ID())
+		assert.Equal(t, version, event.Version())
+	}
+	dis, err := s.Pallet.QueryStateRegister(params.ID(), s.API, test.PastBlocks)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), dis.State.Version)
+	// The other channel is not affected.
+	ctxtest.AssertNotTerminates(t, 2*s.BlockTime, func() { <-otherSub.EventStream() })
+
+	// Stopping closes the stream.
+	require.NoError(t, w.StopWatching(ctx, params.ID()))
+	_, ok := <-sub.EventStream()
+	assert.False(t, ok)
+	assert.NoError(t, sub.Err())
+	assert.ErrorIs(t, w.StopWatching(ctx, params.ID()), pallet.ErrNotWatching)
+}
+
+// TestWatcher_RegisteredBefore checks that the Watcher refutes disputes that
+// were registered before the channel was watched.
+func TestWatcher_RegisteredBefore(t *testing.T) {
+	s := test.NewSetup(t)
+	ctx := s.NewCtx()
+	adjAlice := pallet.NewAdjudicator(s.Alice.Acc, s.Pallet, s.API, test.PastBlocks)
+	adjBob := pallet.NewAdjudicator(s.Bob.Acc, s.Pallet, s.API, test.PastBlocks)
+
+	stale, params, _ := newInitialAdjReq(s, 60)
+	latest := nextVersion(s, stale.Tx)
+	stale.Acc, stale.Idx = s.Bob.Acc, 1
+	require.NoError(t, adjBob.Register(ctx, stale, nil))
+
+	w, err := pallet.NewWatcher(adjAlice)
+	require.NoError(t, err)
+	defer w.Close()
+	_, sub, err := w.StartWatchingLedgerChannel(ctx, pchannel.SignedState{Params: params, State: latest.State, Sigs: latest.Sigs})
+	require.NoError(t, err)
+
+	var event pchannel.AdjudicatorEvent
+	for event == nil || event.Version() != 1 {
+		event = <-sub.EventStream()
+		require.NotNil(t, event)
+	}
+	dis, err := s.Pallet.QueryStateRegister(params.ID(), s.API, test.PastBlocks)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), dis.State.Version)
+}
+
+// TestWatcher_UnreadStream checks that a channel whose event stream is not
+// read does not stop the refutation of other channels.
+func TestWatcher_UnreadStream(t *testing.T) {
+	s := test.NewSetup(t)
+	adjAlice := pallet.NewAdjudicator(s.Alice.Acc, s.Pallet, s.API, test.PastBlocks)
+	adjBob := pallet.NewAdjudicator(s.Bob.Acc, s.Pallet, s.API, test.PastBlocks)
+	w, err := pallet.NewWatcher(adjAlice)
+	require.NoError(t, err)
+	defer w.Close()
+
+	// Alice watches a channel but never reads its events while Bob
+	// registers more versions than the Watcher buffers.
+	unread, unreadParams, _ := newInitialAdjReq(s, 60)
+	_, _, err = w.StartWatchingLedgerChannel(s.NewCtx(), pchannel.SignedState{Params: unreadParams, State: unread.Tx.State, Sigs: unread.Tx.Sigs})
+	require.NoError(t, err)
+	unread.Acc, unread.Idx = s.Bob.Acc, 1
+	for i := 0; i < 2*pallet.WatcherBufferSize+1; i++ {
+		unread.Tx = nextVersion(s, unread.Tx)
+		require.NoError(t, adjBob.Register(s.NewCtx(), unread, nil))
+	}
+
+	// A dispute of another channel is still reported and refuted.
+	ctx := s.NewCtx()
+	stale, params, _ := newInitialAdjReq(s, 60)
+	latest := nextVersion(s, stale.Tx)
+	_, sub, err := w.StartWatchingLedgerChannel(ctx, pchannel.SignedState{Params: params, State: latest.State, Sigs: latest.Sigs})
+	require.NoError(t, err)
+	stale.Acc, stale.Idx = s.Bob.Acc, 1
+	require.NoError(t, adjBob.Register(ctx, stale, nil))
+	for _, version := range []uint64{0, 1} {
+		select {
+		case event := <-sub.EventStream():
+			require.NotNil(t, event)
+			assert.Equal(t, version, event.Version())
+		case <-ctx.Done():
+			t.Fatal("dispute was not reported")
+		}
+	}
+	dis, err := s.Pallet.QueryStateRegister(params.ID(), s.API, test.PastBlocks)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), dis.State.Version)
+}
+
+// TestWatcher_RefuteRetry checks that the Watcher retries a failed
+// refutation with the state that was published in the meantime.
+func TestWatcher_RefuteRetry(t *testing.T) {
+	s := test.NewSetup(t)
+	ctx := s.NewCtx()
+	adjAlice := pallet.NewAdjudicator(s.Alice.Acc, s.Pallet, s.API, test.PastBlocks)
+	adjBob := pallet.NewAdjudicator(s.Bob.Acc, s.Pallet, s.API, test.PastBlocks)
+	w, err := pallet.NewWatcher(adjAlice)
+	require.NoError(t, err)
+	defer w.Close()
+
+	// Alice watches version 1 with an invalid signature, so that the first
+	// refutation fails.
+	stale, params, _ := newInitialAdjReq(s, 60)
+	latest := nextVersion(s, stale.Tx)
+	pub, sub, err := w.StartWatchingLedgerChannel(ctx, pchannel.SignedState{Params: params, State: latest.State, Sigs: corruptSigs(latest.Sigs)})
+	require.NoError(t, err)
+	stale.Acc, stale.Idx = s.Bob.Acc, 1
+	require.NoError(t, adjBob.Register(ctx, stale, nil))
+	event := <-sub.EventStream()
+	require.NotNil(t, event)
+	assert.Equal(t, uint64(0), event.Version())
+
+	// The next attempt registers the newly published version 2.
+	require.NoError(t, pub.Publish(ctx, nextVersion(s, latest)))
+	select {
+	case event = <-sub.EventStream():
+		require.NotNil(t, event)
+		assert.Equal(t, uint64(2), event.Version())
+	case <-ctx.Done():
+		t.Fatal("dispute was not refuted")
+	}
+	assert.NoError(t, sub.Err())
+}
+
+// TestWatcher_RefuteFails checks that the watching fails if a dispute cannot
+// be refuted before its timeout.
+func TestWatcher_RefuteFails(t *testing.T) {
+	s := test.NewSetup(t)
+	ctx := s.NewCtx()
+	adjAlice := pallet.NewAdjudicator(s.Alice.Acc, s.Pallet, s.API, test.PastBlocks)
+	adjBob := pallet.NewAdjudicator(s.Bob.Acc, s.Pallet, s.API, test.PastBlocks)
+	w, err := pallet.NewWatcher(adjAlice)
+	require.NoError(t, err)
+	defer w.Close()
+
+	stale, params, _ := newInitialAdjReq(s, 3)
+	latest := nextVersion(s, stale.Tx)
+	_, sub, err := w.StartWatchingLedgerChannel(ctx, pchannel.SignedState{Params: params, State: latest.State, Sigs: corruptSigs(latest.Sigs)})
+	require.NoError(t, err)
+	stale.Acc, stale.Idx = s.Bob.Acc, 1
+	require.NoError(t, adjBob.Register(ctx, stale, nil))
+
+	// The dispute is reported and the stream is closed once the timeout
+	// elapsed.
+	for event := range sub.EventStream() {
+		assert.Equal(t, uint64(0), event.Version())
+	}
+	assert.Error(t, sub.Err())
+	dis, err := s.Pallet.QueryStateRegister(params.ID(), s.API, test.PastBlocks)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), dis.State.Version)
+}
+
+// corruptSigs returns a copy of the signatures whose first one is invalid.
+func corruptSigs(sigs []pwallet.Sig) []pwallet.Sig {
+	ret := make([]pwallet.Sig, len(sigs))
+	for i, sig := range sigs {
+		ret[i] = append(pwallet.Sig(nil), sig...)
+	}
+	ret[0][0] ^= 0xff
+	return ret
+}
+
+// nextVersion returns a copy of the transaction with an increased version
+// that is signed by all participants.
+func nextVersion(s *test.Setup, tx pchannel.Transaction) pchannel.Transaction {
+	state := tx.State.Clone()
+	state.Version++
+	wState, err := channel.NewState(state)
+	require.NoError(s.T, err)
+	return pchannel.Transaction{State: state, Sigs: s.SignState(wState)}
+}
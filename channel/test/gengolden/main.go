@@ -0,0 +1,56 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command gengolden writes the golden SCALE test vectors of the backend as
+// JSON files. The pallet consumes the same files to check its encoding.
+//
+// Usage:
+//
+//	go run ./channel/test/gengolden -out channel/testdata
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/channel/test"
+)
+
+func main() {
+	out := flag.String("out", ".", "output directory")
+	flag.Parse()
+
+	for _, h := range []channel.Hasher{channel.Keccak256, channel.Blake2b256} {
+		if err := channel.Backend.SetHasher(h); err != nil {
+			log.Fatal(err)
+		}
+		golden, err := test.GenerateGolden()
+		if err != nil {
+			log.Fatalf("generating %v vectors: %v", h, err)
+		}
+		data, err := json.MarshalIndent(golden, "", "\t")
+		if err != nil {
+			log.Fatal(err)
+		}
+		path := filepath.Join(*out, test.GoldenFileName(h))
+		if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil { // nolint: gosec
+			log.Fatal(err)
+		}
+		log.Printf("Wrote %s", path)
+	}
+}
@@ -0,0 +1,328 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+	pchannel "perun.network/go-perun/channel"
+	pwallet "perun.network/go-perun/wallet"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	wallettest "github.com/perun-network/perun-polkadot-backend/wallet/sr25519/test"
+)
+
+type (
+	// GoldenFile contains golden SCALE test vectors for one hasher.
+	// It is shared with the pallet so that both sides are checked against
+	// the same bytes.
+	GoldenFile struct {
+		// Hasher is the name of the hasher that was used for all IDs.
+		Hasher  string         `json:"hasher"`
+		Vectors []GoldenVector `json:"vectors"`
+	}
+
+	// GoldenVector is one set of Params, State, Withdrawal and Fundings
+	// that belong to the same channel.
+	GoldenVector struct {
+		Name       string           `json:"name"`
+		Params     GoldenParams     `json:"params"`
+		State      GoldenState      `json:"state"`
+		Withdrawal GoldenWithdrawal `json:"withdrawal"`
+		Fundings   []GoldenFunding  `json:"fundings"`
+	}
+
+	// GoldenParams are channel.Params with their encoding and channel ID.
+	GoldenParams struct {
+		Nonce             Hex    `json:"nonce"`
+		Participants      []Hex  `json:"participants"`
+		ChallengeDuration uint64 `json:"challenge_duration"`
+		// App is empty if the channel has no app.
+		App       Hex `json:"app"`
+		Encoded   Hex `json:"encoded"`
+		ChannelID Hex `json:"channel_id"`
+	}
+
+	// GoldenState is a channel.State with its encoding and the signatures
+	// of all participants on the encoding.
+	GoldenState struct {
+		Channel Hex    `json:"channel"`
+		Version uint64 `json:"version"`
+		// Balances are decimal strings since they do not fit into a JSON number.
+		Balances []string `json:"balances"`
+		Final    bool     `json:"final"`
+		Data     Hex      `json:"data"`
+		Encoded  Hex      `json:"encoded"`
+		Sigs     []Hex    `json:"sigs"`
+	}
+
+	// GoldenWithdrawal is a channel.Withdrawal with its encoding and the
+	// signature of the participant on the encoding.
+	GoldenWithdrawal struct {
+		Channel  Hex `json:"channel"`
+		Part     Hex `json:"part"`
+		Receiver Hex `json:"receiver"`
+		Encoded  Hex `json:"encoded"`
+		Sig      Hex `json:"sig"`
+	}
+
+	// GoldenFunding is a channel.Funding with its encoding and funding ID.
+	GoldenFunding struct {
+		Channel   Hex `json:"channel"`
+		Part      Hex `json:"part"`
+		Encoded   Hex `json:"encoded"`
+		FundingID Hex `json:"funding_id"`
+	}
+
+	// Hex is a byte slice that is encoded as 0x-prefixed hex string in JSON.
+	Hex []byte
+
+	// goldenInput describes the inputs of one GoldenVector.
+	goldenInput struct {
+		name     string
+		nonce    *big.Int
+		numParts int
+		duration uint64
+		withApp  bool
+		version  uint64
+		bals     []*big.Int
+		final    bool
+	}
+)
+
+// GoldenSeed is the seed from which all golden vector inputs are derived.
+const GoldenSeed = 0x5ca1e
+
+// goldenInputs are the inputs of all golden vectors. Only append to this list,
+// otherwise all existing vectors change.
+var goldenInputs = []goldenInput{
+	{"two-party", big.NewInt(1), 2, 60, false, 0, []*big.Int{big.NewInt(100), big.NewInt(200)}, false},
+	{"final-with-app", big.NewInt(0xdeadbeef), 2, 3600, true, 7, []*big.Int{big.NewInt(0), big.NewInt(1 << 62)}, true},
+	{"three-party-max", new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1)), 3, math.MaxUint64, false, math.MaxUint64, []*big.Int{new(big.Int).Set(channel.MaxBalance.Int), big.NewInt(0), big.NewInt(1)}, false},
+}
+
+// MarshalJSON encodes the Hex as 0x-prefixed hex string.
+func (h Hex) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hexutil.Encode(h))
+}
+
+// UnmarshalJSON decodes a 0x-prefixed hex string.
+func (h *Hex) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	dec, err := hexutil.Decode(str)
+	*h = dec
+	return err
+}
+
+// GenerateGolden generates the golden vectors for the configured hasher of
+// the backend. The inputs are deterministic. The signatures are not, since
+// sr25519 signatures are randomized; they must be verified instead of
+// compared.
+func GenerateGolden() (*GoldenFile, error) {
+	rng := rand.New(rand.NewSource(GoldenSeed)) // nolint: gosec
+	w := wallettest.NewWallet()
+	ret := &GoldenFile{Hasher: channel.Backend.Hasher().String()}
+
+	for _, in := range goldenInputs {
+		accs := make([]pwallet.Account, in.numParts)
+		parts := make([]pwallet.Address, in.numParts)
+		for i := range accs {
+			accs[i] = w.NewRandomAccount(rng)
+			parts[i] = accs[i].Address()
+		}
+		// The receiver is an on-chain account and not part of the channel.
+		receiver := w.NewRandomAccount(rng).Address()
+		var app pchannel.App = pchannel.NoApp()
+		if in.withApp {
+			app = pchannel.NewMockApp(w.NewRandomAccount(rng).Address())
+		}
+
+		vec, err := makeGoldenVector(in, app, accs, parts, receiver)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "vector %s", in.name)
+		}
+		ret.Vectors = append(ret.Vectors, *vec)
+	}
+	return ret, nil
+}
+
+func makeGoldenVector(in goldenInput, app pchannel.App, accs []pwallet.Account, parts []pwallet.Address, receiver pwallet.Address) (*GoldenVector, error) {
+	params, err := pchannel.NewParams(in.duration, parts, app, in.nonce, true, false)
+	if err != nil {
+		return nil, err
+	}
+	var data pchannel.Data = pchannel.NoData()
+	if in.withApp {
+		data = pchannel.NewMockOp(pchannel.OpValid)
+	}
+	state := &pchannel.State{
+		ID:         params.ID(),
+		Version:    in.version,
+		App:        app,
+		Allocation: pchannel.Allocation{Assets: []pchannel.Asset{channel.Asset}, Balances: pchannel.Balances{in.bals}},
+		Data:       data,
+		IsFinal:    in.final,
+	}
+
+	gParams, err := makeGoldenParams(params)
+	if err != nil {
+		return nil, err
+	}
+	gState, err := makeGoldenState(state, accs)
+	if err != nil {
+		return nil, err
+	}
+	gWithdrawal, err := makeGoldenWithdrawal(params.ID(), accs[0], receiver)
+	if err != nil {
+		return nil, err
+	}
+	fundings := make([]GoldenFunding, len(parts))
+	for i, part := range parts {
+		f, err := makeGoldenFunding(params.ID(), part)
+		if err != nil {
+			return nil, err
+		}
+		fundings[i] = *f
+	}
+
+	return &GoldenVector{
+		Name:       in.name,
+		Params:     *gParams,
+		State:      *gState,
+		Withdrawal: *gWithdrawal,
+		Fundings:   fundings,
+	}, nil
+}
+
+func makeGoldenParams(params *pchannel.Params) (*GoldenParams, error) {
+	_params, err := channel.NewParams(params)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := channel.ScaleEncode(_params)
+	if err != nil {
+		return nil, err
+	}
+	cid := channel.CalcID(params)
+	ret := &GoldenParams{
+		Nonce:             _params.Nonce[:],
+		ChallengeDuration: _params.ChallengeDuration,
+		App:               Hex{},
+		Encoded:           enc,
+		ChannelID:         cid[:],
+	}
+	for _, part := range _params.Participants {
+		part := part
+		ret.Participants = append(ret.Participants, part[:])
+	}
+	if !pchannel.IsNoApp(params.App) {
+		ret.App = _params.App[:]
+	}
+	return ret, nil
+}
+
+func makeGoldenState(state *pchannel.State, accs []pwallet.Account) (*GoldenState, error) {
+	_state, err := channel.NewState(state)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := channel.ScaleEncode(_state)
+	if err != nil {
+		return nil, err
+	}
+	ret := &GoldenState{
+		Channel: _state.Channel[:],
+		Version: _state.Version,
+		Final:   _state.Final,
+		Data:    _state.Data,
+		Encoded: enc,
+	}
+	for _, bal := range _state.Balances {
+		ret.Balances = append(ret.Balances, bal.String())
+	}
+	for _, acc := range accs {
+		sig, err := channel.Backend.Sign(acc, state)
+		if err != nil {
+			return nil, err
+		}
+		ret.Sigs = append(ret.Sigs, sig)
+	}
+	return ret, nil
+}
+
+func makeGoldenWithdrawal(cid channel.ChannelID, part pwallet.Account, receiver pwallet.Address) (*GoldenWithdrawal, error) {
+	withdrawal, err := channel.NewWithdrawal(cid, part.Address(), receiver)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := channel.ScaleEncode(withdrawal)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := part.SignData(enc)
+	if err != nil {
+		return nil, err
+	}
+	return &GoldenWithdrawal{
+		Channel:  withdrawal.Channel[:],
+		Part:     withdrawal.Part[:],
+		Receiver: withdrawal.Receiver[:],
+		Encoded:  enc,
+		Sig:      sig,
+	}, nil
+}
+
+func makeGoldenFunding(cid channel.ChannelID, part pwallet.Address) (*GoldenFunding, error) {
+	off, err := channel.MakeOffIdent(part)
+	if err != nil {
+		return nil, err
+	}
+	funding := channel.NewFunding(cid, off)
+	enc, err := channel.ScaleEncode(funding)
+	if err != nil {
+		return nil, err
+	}
+	fid, err := funding.ID()
+	if err != nil {
+		return nil, err
+	}
+	return &GoldenFunding{
+		Channel:   funding.Channel[:],
+		Part:      funding.Part[:],
+		Encoded:   enc,
+		FundingID: fid[:],
+	}, nil
+}
+
+// GoldenFileName returns the name of the golden file for a hasher.
+func GoldenFileName(h channel.Hasher) string {
+	switch h {
+	case channel.Keccak256:
+		return "golden_keccak256.json"
+	case channel.Blake2b256:
+		return "golden_blake2b256.json"
+	default:
+		return fmt.Sprintf("golden_%d.json", uint8(h))
+	}
+}
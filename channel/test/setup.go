@@ -35,11 +35,11 @@ import (
 type (
 	// Setup is the test setup for the channel package tests.
 	Setup struct {
-		T   *testing.T
+		T   testing.TB
 		Rng *rand.Rand
 
-		Accs       []*wallettest.DevAccount
-		Alice, Bob *wallettest.DevAccount
+		Accs                []*wallettest.DevAccount
+		Alice, Bob, Charlie *wallettest.DevAccount
 
 		*subtest.Setup
 	}
@@ -59,11 +59,11 @@ type (
 var DefaultTestTimeout = 50
 
 // NewSetup returns a new setup and assumes that the sr25519 wallet is used.
-func NewSetup(t *testing.T) *Setup {
+func NewSetup(t testing.TB) *Setup {
 	s := subtest.NewSetup(t)
 	accs := wallettest.LoadDevAccounts(t)
 
-	return &Setup{t, pkgtest.Prng(t), accs, accs[0], accs[1], s}
+	return &Setup{t, pkgtest.Prng(t), accs, accs[0], accs[1], accs[2], s}
 }
 
 // SignState returns the signatures for Alice and Bob on the state.
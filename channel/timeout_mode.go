@@ -0,0 +1,63 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// TimeoutMode defines how the pallet interprets challenge durations and
+// dispute timeouts.
+// The numeric values match the `TimeoutMode` constant of the pallet.
+type TimeoutMode uint8
+
+const (
+	// TimestampTimeout interprets challenge durations as seconds and
+	// timeouts as unix timestamps of pallet Timestamp.
+	TimestampTimeout TimeoutMode = iota
+	// BlockTimeout interprets challenge durations as number of blocks and
+	// timeouts as block numbers.
+	BlockTimeout
+
+	// DefaultTimeoutMode is the mode that is used if no other was configured.
+	DefaultTimeoutMode = TimestampTimeout
+)
+
+// ErrUnknownTimeoutMode the timeout mode is not supported.
+var ErrUnknownTimeoutMode = errors.New("unknown timeout mode")
+
+// Valid returns ErrUnknownTimeoutMode if the mode is not supported.
+func (m TimeoutMode) Valid() error {
+	switch m {
+	case TimestampTimeout, BlockTimeout:
+		return nil
+	default:
+		return errors.WithMessagef(ErrUnknownTimeoutMode, "value %d", uint8(m))
+	}
+}
+
+// String returns the name of the timeout mode.
+func (m TimeoutMode) String() string {
+	switch m {
+	case TimestampTimeout:
+		return "Timestamp"
+	case BlockTimeout:
+		return "Block"
+	default:
+		return fmt.Sprintf("TimeoutMode(%d)", uint8(m))
+	}
+}
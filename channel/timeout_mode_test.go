@@ -0,0 +1,91 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channel_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+)
+
+// TestRegisteredState_TimeoutMode checks that the timeout of a
+// RegisteredState is encoded as u64 timestamp or u32 block number.
+func TestRegisteredState_TimeoutMode(t *testing.T) {
+	reg := channel.RegisteredState{
+		Phase: channel.ProgressPhase,
+		State: channel.State{Version: 3, Balances: []channel.Balance{channel.MaxBalance}},
+	}
+	stateEnc, err := channel.ScaleEncode(reg.State)
+	require.NoError(t, err)
+
+	tests := []struct {
+		mode       channel.TimeoutMode
+		timeout    uint64
+		timeoutLen int
+	}{
+		{channel.TimestampTimeout, math.MaxUint64, 8},
+		{channel.BlockTimeout, math.MaxUint32, 4},
+	}
+	for _, tc := range tests {
+		t.Run(tc.mode.String(), func(t *testing.T) {
+			setTimeoutMode(t, tc.mode)
+			reg.Timeout = tc.timeout
+
+			enc, err := channel.ScaleEncode(reg)
+			require.NoError(t, err)
+			assert.Len(t, enc, 1+len(stateEnc)+tc.timeoutLen)
+
+			var dec channel.RegisteredState
+			require.NoError(t, channel.ScaleDecode(&dec, enc))
+			assert.Equal(t, reg, dec)
+		})
+	}
+
+	t.Run("block out of range", func(t *testing.T) {
+		setTimeoutMode(t, channel.BlockTimeout)
+		reg.Timeout = math.MaxUint32 + 1
+		_, err := channel.ScaleEncode(reg)
+		assert.Error(t, err)
+	})
+}
+
+func TestMakeTimeout_Mode(t *testing.T) {
+	setTimeoutMode(t, channel.TimestampTimeout)
+	assert.IsType(t, &substrate.Timeout{}, channel.MakeTimeout(10, nil))
+	setTimeoutMode(t, channel.BlockTimeout)
+	assert.IsType(t, &substrate.BlockTimeout{}, channel.MakeTimeout(10, nil))
+
+	assert.EqualValues(t, math.MaxUint32, channel.MakeBlockNumber(math.MaxUint64))
+}
+
+func TestTimeoutMode_Invalid(t *testing.T) {
+	before := channel.Backend.TimeoutMode()
+	assert.ErrorIs(t, channel.Backend.SetTimeoutMode(channel.TimeoutMode(0xff)), channel.ErrUnknownTimeoutMode)
+	assert.Equal(t, before, channel.Backend.TimeoutMode())
+}
+
+// setTimeoutMode configures the timeout mode and restores the previous one on
+// cleanup.
+func setTimeoutMode(t *testing.T, m channel.TimeoutMode) {
+	t.Helper()
+	before := channel.Backend.TimeoutMode()
+	require.NoError(t, channel.Backend.SetTimeoutMode(m))
+	t.Cleanup(func() { require.NoError(t, channel.Backend.SetTimeoutMode(before)) })
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channel_test
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pwallet "perun.network/go-perun/wallet"
+	pwallettest "perun.network/go-perun/wallet/test"
+	pkgtest "polycry.pt/poly-go/test"
+
+	"github.com/perun-network/perun-polkadot-backend/channel"
+)
+
+// rawAddr is an address that marshals to arbitrary bytes.
+type rawAddr []byte
+
+func newRawAddr(data []byte) *rawAddr {
+	a := rawAddr(data)
+	return &a
+}
+
+func (a rawAddr) MarshalBinary() ([]byte, error)  { return a, nil }
+func (a *rawAddr) UnmarshalBinary(d []byte) error { *a = d; return nil }
+func (a rawAddr) String() string                  { return string(a) }
+func (a rawAddr) Equal(b pwallet.Address) bool    { return false }
+
+func TestMakeSig(t *testing.T) {
+	tests := []struct {
+		name string
+		sig  pwallet.Sig
+		err  error
+	}{
+		{"nil", nil, channel.ErrSigLen},
+		{"empty", pwallet.Sig{}, channel.ErrSigLen},
+		{"short", make(pwallet.Sig, channel.SigLen-1), channel.ErrSigLen},
+		{"long", make(pwallet.Sig, channel.SigLen+1), channel.ErrSigLen},
+		{"valid", bytes.Repeat([]byte{7}, channel.SigLen), nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sig, err := channel.MakeSig(tc.sig)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []byte(tc.sig), sig[:])
+		})
+	}
+}
+
+func TestMakeSigs(t *testing.T) {
+	valid := make(pwallet.Sig, channel.SigLen)
+	sigs, err := channel.MakeSigs([]pwallet.Sig{valid, valid})
+	require.NoError(t, err)
+	assert.Len(t, sigs, 2)
+
+	_, err = channel.MakeSigs([]pwallet.Sig{valid, valid[1:]})
+	assert.ErrorIs(t, err, channel.ErrSigLen)
+	assert.Contains(t, err.Error(), "sig 1")
+}
+
+func TestMakeNonce(t *testing.T) {
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 8*channel.NonceLen), big.NewInt(1))
+	tests := []struct {
+		name  string
+		nonce *big.Int
+		err   error
+	}{
+		{"nil", nil, channel.ErrNonceOutOfRange},
+		{"zero", big.NewInt(0), channel.ErrNonceOutOfRange},
+		{"negative", big.NewInt(-1), channel.ErrNonceOutOfRange},
+		{"too big", new(big.Int).Add(max, big.NewInt(1)), channel.ErrNonceOutOfRange},
+		{"one", big.NewInt(1), nil},
+		{"max", max, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := channel.MakeNonce(tc.nonce)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMakeIdent(t *testing.T) {
+	rng := pkgtest.Prng(t)
+	valid := pwallettest.NewRandomAddress(rng)
+	// Not a canonical ristretto255 encoding.
+	invalidPoint := newRawAddr(bytes.Repeat([]byte{0xff}, channel.OffIdentityLen))
+
+	tests := []struct {
+		name   string
+		addr   pwallet.Address
+		offErr error
+		onErr  error
+	}{
+		{"nil", nil, channel.ErrIdentLenMismatch, channel.ErrIdentLenMismatch},
+		{"empty", newRawAddr(nil), channel.ErrIdentLenMismatch, channel.ErrIdentLenMismatch},
+		{"short", newRawAddr(make([]byte, channel.OffIdentityLen-1)), channel.ErrIdentLenMismatch, channel.ErrIdentLenMismatch},
+		{"long", newRawAddr(make([]byte, channel.OffIdentityLen+1)), channel.ErrIdentLenMismatch, channel.ErrIdentLenMismatch},
+		{"invalid point", invalidPoint, channel.ErrInvalidPoint, nil},
+		{"valid", valid, nil, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := channel.MakeOffIdent(tc.addr)
+			if tc.offErr != nil {
+				assert.ErrorIs(t, err, tc.offErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			_, err = channel.MakeOnIdent(tc.addr)
+			if tc.onErr != nil {
+				assert.ErrorIs(t, err, tc.onErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	_, err := channel.MakeOffIdents([]pwallet.Address{valid, invalidPoint})
+	assert.ErrorIs(t, err, channel.ErrInvalidPoint)
+	assert.Contains(t, err.Error(), "participant 1")
+}
@@ -41,6 +41,10 @@ type API struct {
 // detected.
 var ErrWrongNodeVersion = errors.New("wrong node version")
 
+// ErrAccountNotFound the account does not exist on-chain, which is the case
+// for accounts that were never funded.
+var ErrAccountNotFound = errors.New("account not found")
+
 // NewAPI creates a new `Api` object. Can be retried in the case of an error.
 func NewAPI(url string, network NetworkID) (*API, error) {
 	log.WithField("url", url).Debugf("Connecting to node")
@@ -60,6 +64,7 @@ func NewAPI(url string, network NetworkID) (*API, error) {
 
 // AccountInfo returns the account info for an Address.
 // Can be used to retrieve the free balance, nonce, and other.
+// Returns an error that wraps ErrAccountNotFound for unknown accounts.
 func (a *API) AccountInfo(addr types.AccountID) (AccountInfo, error) {
 	key, err := types.CreateStorageKey(a.Metadata(), "System", "Account", addr[:])
 	if err != nil {
@@ -70,10 +75,13 @@ func (a *API) AccountInfo(addr types.AccountID) (AccountInfo, error) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 	ok, err := a.api.RPC.State.GetStorageLatest(key, &info)
+	if err != nil {
+		return AccountInfo{}, err
+	}
 	if !ok {
-		return AccountInfo{}, errors.Errorf("account not found: 0x%x", addr)
+		return AccountInfo{}, errors.WithMessagef(ErrAccountNotFound, "0x%x", addr)
 	}
-	return info, err
+	return info, nil
 }
 
 // Metadata returns the metadata of the chain. The value is cached on startup.
@@ -177,6 +185,30 @@ func (a *API) LastHeader() (*types.Header, error) {
 	return a.api.RPC.Chain.GetHeaderLatest()
 }
 
+// AccountNextIndex returns the next nonce of an account that is not used by
+// the account's Extrinsics in the transaction pool.
+func (a *API) AccountNextIndex(addr types.AccountID) (uint64, error) {
+	ss58, err := SS58Address(addr, a.network)
+	if err != nil {
+		return 0, err
+	}
+	var nonce uint64
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if err := a.api.Client.Call(&nonce, "system_accountNextIndex", ss58); err != nil {
+		return 0, errors.WithMessage(err, "querying next account index")
+	}
+	return nonce, nil
+}
+
+// FinalizedHead returns the hash of the last finalized block.
+func (a *API) FinalizedHead() (types.Hash, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	return a.api.RPC.Chain.GetFinalizedHead()
+}
+
 // SubscribeHeaders subscribes to new headers.
 func (a *API) SubscribeHeaders() (*chain.NewHeadsSubscription, error) {
 	a.mtx.Lock()
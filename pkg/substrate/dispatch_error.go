@@ -32,22 +32,43 @@ var (
 // DecodeError decodes an error into a human-readable form.
 // Returns either ErrUnknownError or ErrCallFailed.
 func DecodeError(meta *types.Metadata, err types.DispatchError) error {
+	_, e, merr := ModuleError(meta, err)
+	if merr != nil {
+		return merr
+	}
+	return errors.Wrap(ErrCallFailed, formatErrorMeta(e))
+}
+
+// ModuleError returns the name of the module that returned a dispatch error
+// and the metadata of the error. Returns ErrUnknownError if the error was not
+// returned by a module or is not in the metadata.
+func ModuleError(meta *types.Metadata, err types.DispatchError) (string, types.ErrorMetadataV8, error) {
 	metaV, ok := Meta(meta)
 	if !ok {
-		return errors.Wrap(ErrUnknownError, "wrong meta data version")
+		return "", types.ErrorMetadataV8{}, errors.Wrap(ErrUnknownError, "wrong meta data version")
 	}
 	if !err.HasModule {
-		return errors.Wrap(ErrUnknownError, "no module in error")
+		return "", types.ErrorMetadataV8{}, errors.Wrap(ErrUnknownError, "no module in error")
 	}
-	if int(err.Module) >= len(metaV.Modules) {
-		return errors.Wrap(ErrUnknownError, "module index out of range")
+	for _, module := range metaV.Modules {
+		if module.Index != err.Module {
+			continue
+		}
+		if int(err.Error) >= len(module.Errors) {
+			return "", types.ErrorMetadataV8{}, errors.Wrap(ErrUnknownError, "error index out of range")
+		}
+		return string(module.Name), module.Errors[err.Error], nil
 	}
-	module := metaV.Modules[err.Module]
-	if int(err.Error) >= len(module.Errors) {
-		return errors.Wrap(ErrUnknownError, "error index out of range")
+	return "", types.ErrorMetadataV8{}, errors.Wrap(ErrUnknownError, "module index out of range")
+}
+
+// FormatErrorDocs joins the documentation of an error.
+func FormatErrorDocs(err types.ErrorMetadataV8) string {
+	docs := make([]string, len(err.Documentation))
+	for i, doc := range err.Documentation {
+		docs[i] = strings.TrimSpace(string(doc))
 	}
-	e := module.Errors[err.Error]
-	return errors.Wrap(ErrCallFailed, formatErrorMeta(e))
+	return strings.Join(docs, " ")
 }
 
 // formatErrorMeta formats an ErrorMetaDataV8 into a human-readable form.
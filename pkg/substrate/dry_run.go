@@ -0,0 +1,121 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package substrate
+
+import (
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrTxInvalid the transaction pool would reject an Extrinsic.
+	ErrTxInvalid = errors.New("invalid transaction")
+	// ErrTxFuture the nonce of an Extrinsic is higher than the next nonce of
+	// its account on-chain. The pool accepts it but can not execute it yet.
+	// Wraps ErrTxInvalid.
+	ErrTxFuture = errors.Wrap(ErrTxInvalid, "Future")
+)
+
+// futureTx is the index of InvalidTransaction::Future.
+const futureTx = 2
+
+// invalidTxs names the variants of InvalidTransaction.
+var invalidTxs = []string{
+	"Call", "Payment", "Future", "Stale", "BadProof", "AncientBirthBlock",
+	"ExhaustsResources", "Custom", "BadMandatory", "MandatoryDispatch",
+}
+
+// unknownTxs names the variants of UnknownTransaction.
+var unknownTxs = []string{"CannotLookup", "NoUnsignedValidator", "Custom"}
+
+// DryRun executes a signed Extrinsic on top of the latest block without
+// submitting it. Returns the dispatch error of its call or nil if the call
+// succeeds. Returns ErrTxInvalid if the Extrinsic would not be included and
+// ErrTxFuture if it can only be included after other Extrinsics of its
+// account, whose call can then not be checked.
+// system_dryRun is an unsafe RPC method, so the node must run with
+// --rpc-methods=Unsafe.
+func (a *API) DryRun(ext *types.Extrinsic) (*types.DispatchError, error) {
+	enc, err := types.EncodeToHexString(*ext)
+	if err != nil {
+		return nil, err
+	}
+	var res string
+	a.mtx.Lock()
+	err = a.api.Client.Call(&res, "system_dryRun", enc)
+	a.mtx.Unlock()
+	if err != nil {
+		return nil, errors.WithMessage(err, "dry run")
+	}
+	data, err := types.HexDecodeString(res)
+	if err != nil {
+		return nil, errors.WithMessage(err, "decoding dry run result")
+	}
+	return decodeApplyResult(data)
+}
+
+// decodeApplyResult decodes a SCALE encoded ApplyExtrinsicResult, which is a
+// Result<Result<(), DispatchError>, TransactionValidityError>.
+func decodeApplyResult(data []byte) (*types.DispatchError, error) {
+	if len(data) < 2 {
+		return nil, errors.Errorf("dry run result too short: 0x%x", data)
+	}
+	switch data[0] {
+	case 0: // Ok(DispatchOutcome)
+		if data[1] == 0 {
+			return nil, nil
+		}
+		return decodeDispatchError(data[2:])
+	case 1: // Err(TransactionValidityError)
+		return nil, validityError(data[1:])
+	default:
+		return nil, errors.Errorf("invalid dry run result: 0x%x", data)
+	}
+}
+
+// decodeDispatchError decodes a DispatchError. Errors other than module
+// errors only carry their variant index in the Error field.
+func decodeDispatchError(data []byte) (*types.DispatchError, error) {
+	if len(data) == 0 {
+		return nil, errors.New("dispatch error too short")
+	}
+	// Enum index 3 is a module error.
+	if data[0] != 3 {
+		return &types.DispatchError{Error: data[0]}, nil
+	}
+	if len(data) < 3 {
+		return nil, errors.Errorf("module error too short: 0x%x", data)
+	}
+	return &types.DispatchError{HasModule: true, Module: data[1], Error: data[2]}, nil
+}
+
+// validityError converts a SCALE encoded TransactionValidityError into an
+// error that wraps ErrTxInvalid.
+func validityError(data []byte) error {
+	if len(data) < 2 {
+		return errors.Wrapf(ErrTxInvalid, "0x%x", data)
+	}
+	if data[0] == 0 && data[1] == futureTx {
+		return ErrTxFuture
+	}
+	names := invalidTxs
+	if data[0] == 1 {
+		names = unknownTxs
+	}
+	if int(data[1]) >= len(names) {
+		return errors.Wrapf(ErrTxInvalid, "0x%x", data)
+	}
+	return errors.Wrap(ErrTxInvalid, names[data[1]])
+}
@@ -0,0 +1,60 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package substrate
+
+import (
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeApplyResult(t *testing.T) {
+	// Ok(Ok(()))
+	dErr, err := decodeApplyResult([]byte{0, 0})
+	require.NoError(t, err)
+	assert.Nil(t, dErr)
+
+	// Ok(Err(Module { index: 8, error: 5 }))
+	dErr, err = decodeApplyResult([]byte{0, 1, 3, 8, 5})
+	require.NoError(t, err)
+	assert.Equal(t, &types.DispatchError{HasModule: true, Module: 8, Error: 5}, dErr)
+
+	// Ok(Err(BadOrigin))
+	dErr, err = decodeApplyResult([]byte{0, 1, 2})
+	require.NoError(t, err)
+	assert.Equal(t, &types.DispatchError{Error: 2}, dErr)
+
+	// Err(Invalid(Stale))
+	_, err = decodeApplyResult([]byte{1, 0, 3})
+	assert.ErrorIs(t, err, ErrTxInvalid)
+	assert.Contains(t, err.Error(), "Stale")
+
+	// Err(Invalid(Future))
+	_, err = decodeApplyResult([]byte{1, 0, 2})
+	assert.ErrorIs(t, err, ErrTxFuture)
+	assert.ErrorIs(t, err, ErrTxInvalid)
+
+	// Err(Unknown(CannotLookup))
+	_, err = decodeApplyResult([]byte{1, 1, 0})
+	assert.ErrorIs(t, err, ErrTxInvalid)
+	assert.Contains(t, err.Error(), "CannotLookup")
+
+	for _, data := range [][]byte{{}, {0}, {2, 0}, {0, 1}, {0, 1, 3, 8}} {
+		_, err = decodeApplyResult(data)
+		assert.Error(t, err, "0x%x", data)
+	}
+}
@@ -31,8 +31,19 @@ type (
 		future *state.StorageSubscription
 		api    *API
 
-		events chan types.EventRecordsRaw
+		events chan BlockRecords
 		err    chan error
+		// past contains the blocks of the past events. Future events of these
+		// blocks are skipped since the subscription can start with them.
+		past map[types.Hash]bool
+	}
+
+	// BlockRecords are the raw event records of one block.
+	BlockRecords struct {
+		// Block is the hash of the block.
+		Block types.Hash
+		// Records are the event records of the block.
+		Records types.EventRecordsRaw
 	}
 
 	// EventKey identifies an event type that an EventSource can listen on.
@@ -75,7 +86,7 @@ func NewEventSource(api *API, pastBlocks types.BlockNumber, keys ...*EventKey) (
 		return nil, err
 	}
 
-	events := make(chan types.EventRecordsRaw, ChanBuffSize)
+	events := make(chan BlockRecords, ChanBuffSize)
 	source := &EventSource{
 		Closer:    new(pkgsync.Closer),
 		Embedding: log.MakeEmbedding(log.Default()),
@@ -83,6 +94,7 @@ func NewEventSource(api *API, pastBlocks types.BlockNumber, keys ...*EventKey) (
 		api:       api,
 		events:    events,
 		err:       make(chan error, 1),
+		past:      make(map[types.Hash]bool),
 	}
 	source.OnClose(func() {
 		future.Unsubscribe()
@@ -106,6 +118,7 @@ func (s *EventSource) init(keys []types.StorageKey, pastBlocks types.BlockNumber
 	// Process all past events.
 	// This could dead-lock if there are more events than the channel can hold.
 	for _, set := range past {
+		s.past[set.Block] = true
 		s.parseEvent(set)
 	}
 	// Listen to all future events.
@@ -116,6 +129,10 @@ func (s *EventSource) init(keys []types.StorageKey, pastBlocks types.BlockNumber
 		for {
 			select {
 			case set := <-s.future.Chan():
+				if s.past[set.Block] {
+					continue
+				}
+				s.past = nil // All further blocks are new.
 				s.parseEvent(set)
 			case err := <-s.future.Err():
 				s.err <- err
@@ -136,13 +153,13 @@ func (s *EventSource) parseEvent(set types.StorageChangeSet) {
 			continue
 		}
 
-		s.events <- types.EventRecordsRaw(change.StorageData)
+		s.events <- BlockRecords{set.Block, types.EventRecordsRaw(change.StorageData)}
 	}
 }
 
 // Events returns a channel that contains all events that the EventSource found.
 // This channel will never be closed.
-func (s *EventSource) Events() <-chan types.EventRecordsRaw {
+func (s *EventSource) Events() <-chan BlockRecords {
 	return s.events
 }
 
@@ -16,8 +16,11 @@ package substrate
 
 import (
 	"fmt"
+	"math/big"
 
 	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
 )
 
 type (
@@ -83,3 +86,26 @@ func (b *ExtFactory) SigOptions(addr types.AccountID) (*types.SignatureOptions,
 func (f *ExtName) String() string {
 	return fmt.Sprintf("%s.%s", f.pallet, f.function)
 }
+
+// ExtHash returns the hash of a signed Extrinsic as it is used by substrate
+// to identify Extrinsics in the pool and in blocks.
+func ExtHash(ext *types.Extrinsic) (types.Hash, error) {
+	data, err := types.EncodeToBytes(ext)
+	if err != nil {
+		return types.Hash{}, err
+	}
+	return blake2b.Sum256(data), nil
+}
+
+// ExtNonce returns the nonce of a signed Extrinsic.
+func ExtNonce(ext *types.Extrinsic) uint64 {
+	return (*big.Int)(&ext.Signature.Nonce).Uint64()
+}
+
+// ExtSignerID returns the account that signed the Extrinsic.
+func ExtSignerID(ext *types.Extrinsic) (types.AccountID, error) {
+	if !ext.IsSigned() || !ext.Signature.Signer.IsID {
+		return types.AccountID{}, errors.New("extrinsic is not signed by an account ID")
+	}
+	return ext.Signature.Signer.AsID, nil
+}
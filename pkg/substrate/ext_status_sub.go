@@ -36,6 +36,16 @@ type (
 	ExtStatusPred func(*types.ExtrinsicStatus) bool
 )
 
+var (
+	// ErrExtDropped the Extrinsic was dropped from the transaction pool.
+	ErrExtDropped = errors.New("extrinsic dropped")
+	// ErrExtInvalid the Extrinsic became invalid in the transaction pool.
+	ErrExtInvalid = errors.New("extrinsic invalid")
+	// ErrExtUsurped the Extrinsic was replaced by another one with the same
+	// nonce.
+	ErrExtUsurped = errors.New("extrinsic usurped")
+)
+
 // NewExtStatusSub returns a new ExtStatusSub and takes ownership of the passed sub.
 func NewExtStatusSub(sub *author.ExtrinsicStatusSubscription) *ExtStatusSub {
 	ret := &ExtStatusSub{sub: sub}
@@ -45,6 +55,8 @@ func NewExtStatusSub(sub *author.ExtrinsicStatusSubscription) *ExtStatusSub {
 
 // WaitUntil waits until the predicate returns true or the context is cancelled.
 // Can be used for example to wait until an Extrinsic is final with `ExtIsFinal`.
+// Returns ErrExtDropped, ErrExtInvalid or ErrExtUsurped if the Extrinsic left
+// the transaction pool without being included.
 func (e *ExtStatusSub) WaitUntil(ctx context.Context, until ExtStatusPred) error {
 	for {
 		select {
@@ -52,6 +64,9 @@ func (e *ExtStatusSub) WaitUntil(ctx context.Context, until ExtStatusPred) error
 			if until(&status) {
 				return nil
 			}
+			if err := extFailed(&status); err != nil {
+				return err
+			}
 		case err := <-e.sub.Err():
 			return errors.Errorf("underlying sub closed: %v", err)
 		case <-ctx.Done():
@@ -67,6 +82,21 @@ func (e *ExtStatusSub) Close() {
 	}
 }
 
+// extFailed returns an error if the status indicates that the Extrinsic will
+// not be included anymore.
+func extFailed(status *types.ExtrinsicStatus) error {
+	switch {
+	case status.IsDropped:
+		return ErrExtDropped
+	case status.IsInvalid:
+		return ErrExtInvalid
+	case status.IsUsurped:
+		return errors.WithMessagef(ErrExtUsurped, "by 0x%x", status.AsUsurped)
+	default:
+		return nil
+	}
+}
+
 // ExtIsFinal returns whether an Extrinsic is final.
 func ExtIsFinal(status *types.ExtrinsicStatus) bool {
 	return status.IsFinalized
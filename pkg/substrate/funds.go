@@ -0,0 +1,124 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package substrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
+)
+
+// InsufficientFundsError is returned if an account cannot afford a transfer
+// plus fees while staying above the existential deposit.
+// It matches ErrInsufficientFunds with errors.Is.
+type InsufficientFundsError struct {
+	// Account is the account that would pay.
+	Account types.AccountID
+	// Required is the amount plus fees plus the existential deposit.
+	Required *big.Int
+	// Available is the transferable balance of the account.
+	Available *big.Int
+}
+
+// ErrInsufficientFunds an account has not enough funds.
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// Shortfall returns the amount that the account is missing.
+func (e InsufficientFundsError) Shortfall() *big.Int {
+	return new(big.Int).Sub(e.Required, e.Available)
+}
+
+// Error returns the error message including the shortfall.
+func (e InsufficientFundsError) Error() string {
+	return fmt.Sprintf("%v: account 0x%x needs %v but has %v available, shortfall %v",
+		ErrInsufficientFunds, e.Account, e.Required, e.Available, e.Shortfall())
+}
+
+// Is returns true for ErrInsufficientFunds.
+func (e InsufficientFundsError) Is(target error) bool {
+	return target == ErrInsufficientFunds
+}
+
+// ExistentialDeposit returns the existential deposit of pallet Balances.
+// An account whose balance drops below it is reaped.
+func (a *API) ExistentialDeposit() (*big.Int, error) {
+	data, err := a.Metadata().FindConstantValue("Balances", "ExistentialDeposit")
+	if err != nil {
+		return nil, err
+	}
+	var ed types.U128
+	if err := types.DecodeFromBytes(data, &ed); err != nil {
+		return nil, errors.WithMessage(err, "decoding existential deposit")
+	}
+	return ed.Int, nil
+}
+
+// EstimateFee returns the fee of an extrinsic as estimated by the node.
+// The signature of the extrinsic is not checked, it can be a placeholder.
+func (a *API) EstimateFee(ext *types.Extrinsic) (*big.Int, error) {
+	enc, err := types.EncodeToHexString(*ext)
+	if err != nil {
+		return nil, err
+	}
+	var info struct {
+		PartialFee json.RawMessage `json:"partialFee"`
+	}
+	a.mtx.Lock()
+	err = a.api.Client.Call(&info, "payment_queryInfo", enc)
+	a.mtx.Unlock()
+	if err != nil {
+		return nil, errors.WithMessage(err, "querying fee")
+	}
+	// Depending on the node version, the fee is a number or a string.
+	fee, ok := new(big.Int).SetString(strings.Trim(string(info.PartialFee), `"`), 0)
+	if !ok {
+		return nil, errors.Errorf("invalid fee: %s", info.PartialFee)
+	}
+	return fee, nil
+}
+
+// CheckFunds checks that the account can pay `amount` plus `fee` and stays
+// above the existential deposit. Returns an InsufficientFundsError otherwise.
+// Accounts that do not exist on-chain have no funds.
+func (a *API) CheckFunds(addr types.AccountID, amount, fee *big.Int) error {
+	info, err := a.AccountInfo(addr)
+	if errors.Is(err, ErrAccountNotFound) {
+		zero := types.NewU128(*new(big.Int))
+		info = AccountInfo{Free: zero, MiscFrozen: zero, FreeFrozen: zero}
+	} else if err != nil {
+		return err
+	}
+	ed, err := a.ExistentialDeposit()
+	if err != nil {
+		return err
+	}
+
+	// Frozen funds can neither be transferred nor used for fees.
+	frozen := info.MiscFrozen.Int
+	if info.FreeFrozen.Cmp(frozen) > 0 {
+		frozen = info.FreeFrozen.Int
+	}
+	available := new(big.Int).Sub(info.Free.Int, frozen)
+	required := new(big.Int).Add(amount, fee)
+	required.Add(required, ed)
+	if available.Cmp(required) < 0 {
+		return InsufficientFundsError{Account: addr, Required: required, Available: available}
+	}
+	return nil
+}
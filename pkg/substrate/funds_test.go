@@ -0,0 +1,83 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package substrate_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pkgtest "polycry.pt/poly-go/test"
+
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/perun-network/perun-polkadot-backend/pkg/substrate/test"
+	"github.com/perun-network/perun-polkadot-backend/wallet"
+	wallettest "github.com/perun-network/perun-polkadot-backend/wallet/sr25519/test"
+)
+
+func TestInsufficientFundsError(t *testing.T) {
+	err := error(substrate.InsufficientFundsError{Required: big.NewInt(10), Available: big.NewInt(3)})
+
+	assert.ErrorIs(t, err, substrate.ErrInsufficientFunds)
+	assert.ErrorIs(t, errors.WithMessage(err, "depositing"), substrate.ErrInsufficientFunds)
+	var fundsErr substrate.InsufficientFundsError
+	require.True(t, errors.As(err, &fundsErr))
+	assert.Equal(t, big.NewInt(7), fundsErr.Shortfall())
+	assert.Contains(t, err.Error(), "shortfall 7")
+}
+
+func TestAPI_CheckFunds(t *testing.T) {
+	s := test.NewSetup(t)
+	alice := wallet.AsAddr(wallettest.LoadDevAccounts(t)[0].Acc.Address()).AccountID()
+	info, err := s.API.AccountInfo(alice)
+	require.NoError(t, err)
+	ed, err := s.API.ExistentialDeposit()
+	require.NoError(t, err)
+	assert.Greater(t, ed.Sign(), 0)
+	fee := big.NewInt(1000)
+
+	// Alice can pay everything except the existential deposit.
+	amount := new(big.Int).Sub(info.Free.Int, fee)
+	err = s.API.CheckFunds(alice, amount, fee)
+	require.ErrorIs(t, err, substrate.ErrInsufficientFunds)
+	var fundsErr substrate.InsufficientFundsError
+	require.True(t, errors.As(err, &fundsErr))
+	assert.Equal(t, ed, fundsErr.Shortfall())
+
+	// Alice can pay if she keeps the existential deposit.
+	amount.Sub(amount, ed)
+	assert.NoError(t, s.API.CheckFunds(alice, amount, fee))
+	// An unknown account has no funds.
+	assert.ErrorIs(t, s.API.CheckFunds(types.AccountID{}, big.NewInt(1), fee), substrate.ErrInsufficientFunds)
+}
+
+// TestAPI_CheckFundsNewAccount checks that a never funded account has
+// insufficient funds instead of failing to read its account.
+func TestAPI_CheckFundsNewAccount(t *testing.T) {
+	s := test.NewSetup(t)
+	acc := wallettest.NewWallet().NewRandomAccount(pkgtest.Prng(t))
+	addr := wallet.AsAddr(acc.Address()).AccountID()
+	_, err := s.API.AccountInfo(addr)
+	require.ErrorIs(t, err, substrate.ErrAccountNotFound)
+
+	err = s.API.CheckFunds(addr, big.NewInt(1), big.NewInt(1000))
+	require.ErrorIs(t, err, substrate.ErrInsufficientFunds)
+	var fundsErr substrate.InsufficientFundsError
+	require.True(t, errors.As(err, &fundsErr))
+	assert.Zero(t, fundsErr.Available.Sign())
+}
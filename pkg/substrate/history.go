@@ -0,0 +1,89 @@
+// Copyright 2021 PolyCrypt GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package substrate
+
+import (
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
+)
+
+// HistoryReader reads past blocks and their storage.
+type HistoryReader interface {
+	// Metadata returns the latest metadata.
+	Metadata() *types.Metadata
+	// LastHeader returns the last header.
+	LastHeader() (*types.Header, error)
+	// BlockHash returns the block hash for the given block number.
+	BlockHash(uint64) (types.Hash, error)
+	// QueryAt returns the value of a storage key at the end of the block
+	// with the given hash. The value is empty if the key is not set.
+	QueryAt(block types.Hash, key types.StorageKey) (types.StorageDataRaw, error)
+	// Block returns the block with the given hash.
+	Block(types.Hash) (*types.SignedBlock, error)
+	// TimestampAt returns the on-chain time of the block with the given
+	// hash.
+	TimestampAt(types.Hash) (time.Time, error)
+}
+
+// Block returns the block with the given hash.
+func (a *API) Block(hash types.Hash) (*types.SignedBlock, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	return a.api.RPC.Chain.GetBlock(hash)
+}
+
+// Header returns the header of the block with the given hash.
+func (a *API) Header(hash types.Hash) (*types.Header, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	return a.api.RPC.Chain.GetHeader(hash)
+}
+
+// QueryAt returns the value of a storage key at the end of the block with the
+// given hash. The value is empty if the key is not set.
+func (a *API) QueryAt(block types.Hash, key types.StorageKey) (types.StorageDataRaw, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	data, err := a.api.RPC.State.GetStorageRaw(key, block)
+	if err != nil {
+		return nil, err
+	}
+	return *data, nil
+}
+
+// TimestampAt returns the on-chain time of the block with the given hash in
+// millisecond precision.
+func (a *API) TimestampAt(hash types.Hash) (time.Time, error) {
+	key, err := a.BuildKey("Timestamp", "Now")
+	if err != nil {
+		return time.Unix(0, 0), err
+	}
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	var now TimePoint
+	ok, err := a.api.RPC.State.GetStorage(key, &now, hash)
+	if err != nil {
+		return time.Unix(0, 0), err
+	} else if !ok {
+		return time.Unix(0, 0), errors.Errorf("no timestamp in block 0x%x", hash)
+	}
+	return time.Unix(0, int64(now)*int64(time.Millisecond)), nil
+}
@@ -14,7 +14,12 @@
 
 package substrate
 
-import "github.com/centrifuge/go-substrate-rpc-client/v3/types"
+import (
+	"math/big"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
+)
 
 // Pallet binds to a pallet that is deployed on a substrate chain.
 type Pallet struct {
@@ -40,6 +45,86 @@ func (p *Pallet) Subscribe(pastBlocks types.BlockNumber) (*EventSource, error) {
 	return NewEventSource(p.api, pastBlocks, SystemEventsKey())
 }
 
+// AccountNonce returns the nonce of the next Extrinsic of `addr`.
+func (p *Pallet) AccountNonce(addr types.AccountID) (uint64, error) {
+	info, err := p.api.AccountInfo(addr)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(info.Nonce), nil
+}
+
+// ExtIndex returns the position of an Extrinsic in the block with the given
+// hash.
+func (p *Pallet) ExtIndex(block types.Hash, ext *types.Extrinsic) (int, error) {
+	want, err := ExtHash(ext)
+	if err != nil {
+		return 0, err
+	}
+	b, err := p.api.Block(block)
+	if err != nil {
+		return 0, err
+	}
+	for i := range b.Block.Extrinsics {
+		hash, err := ExtHash(&b.Block.Extrinsics[i])
+		if err != nil {
+			return 0, err
+		}
+		if hash == want {
+			return i, nil
+		}
+	}
+	return 0, errors.Errorf("extrinsic 0x%x not in block 0x%x", want, block)
+}
+
+// FindExt searches the blocks from the latest one back to block number
+// `since` for an Extrinsic with the given hash. Returns the hash of the block
+// that includes it and false if none does.
+func (p *Pallet) FindExt(ext types.Hash, since types.BlockNumber) (types.Hash, bool, error) {
+	head, err := p.api.LastHeader()
+	if err != nil {
+		return types.Hash{}, false, err
+	}
+	hash, err := p.api.BlockHash(uint64(head.Number))
+	if err != nil {
+		return types.Hash{}, false, err
+	}
+	for {
+		b, err := p.api.Block(hash)
+		if err != nil {
+			return types.Hash{}, false, err
+		}
+		for i := range b.Block.Extrinsics {
+			h, err := ExtHash(&b.Block.Extrinsics[i])
+			if err != nil {
+				return types.Hash{}, false, err
+			}
+			if h == ext {
+				return hash, true, nil
+			}
+		}
+		if b.Block.Header.Number <= since || b.Block.Header.Number == 0 {
+			return types.Hash{}, false, nil
+		}
+		hash = b.Block.Header.ParentHash
+	}
+}
+
+// BlockNumber returns the number of the latest block.
+func (p *Pallet) BlockNumber() (types.BlockNumber, error) {
+	return ChainBlockNumber(p.api)
+}
+
+// EventsAt returns the raw event records of the block with the given hash.
+func (p *Pallet) EventsAt(block types.Hash) (types.EventRecordsRaw, error) {
+	key, err := SystemEventsKey().Key(p.api.Metadata())
+	if err != nil {
+		return nil, err
+	}
+	data, err := p.api.QueryAt(block, key)
+	return types.EventRecordsRaw(data), err
+}
+
 func (p *Pallet) BuildQuery(variable string, args ...[]byte) (types.StorageKey, error) {
 	return p.api.BuildKey(p.name, variable, args...)
 }
@@ -59,3 +144,85 @@ func (p *Pallet) BuildExt(call *ExtName, args []interface{}, addr types.AccountI
 	// Sign the ext.
 	return ext, signer.SignExt(ext, *opts, p.api.Network())
 }
+
+// Resign returns a new Extrinsic with the call of `ext` that is signed with
+// the given tip and the next nonce of `addr` that is free in the transaction
+// pool, so that it does not replace other pending Extrinsics of `addr`.
+// Can be used to resubmit an Extrinsic that was dropped or rejected.
+func (p *Pallet) Resign(ext *types.Extrinsic, addr types.AccountID, signer ExtSigner, tip *big.Int) (*types.Extrinsic, error) {
+	ret := types.NewExtrinsic(ext.Method)
+	opts, err := p.ext.SigOptions(addr)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := p.api.AccountNextIndex(addr)
+	if err != nil {
+		return nil, err
+	}
+	opts.Nonce = types.NewUCompactFromUInt(nonce)
+	opts.Tip = types.NewUCompact(tip)
+	return &ret, signer.SignExt(&ret, *opts, p.api.Network())
+}
+
+// BuildExtWithNonce builds and signs an extrinsic with the given nonce
+// instead of the on-chain nonce of `addr`. Can be used to send multiple
+// extrinsics of the same account at once.
+func (p *Pallet) BuildExtWithNonce(call *ExtName, args []interface{}, addr types.AccountID, signer ExtSigner, nonce uint64) (*types.Extrinsic, error) {
+	ext, err := p.ext.BuildExt(call, args)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := p.ext.SigOptions(addr)
+	if err != nil {
+		return nil, err
+	}
+	opts.Nonce = types.NewUCompactFromUInt(nonce)
+	return ext, signer.SignExt(ext, *opts, p.api.Network())
+}
+
+// EstimateFee estimates the fee of a call if it was signed by `addr`.
+// Nothing is signed, the extrinsic carries a placeholder signature.
+func (p *Pallet) EstimateFee(call *ExtName, args []interface{}, addr types.AccountID) (*big.Int, error) {
+	ext, err := p.ext.BuildExt(call, args)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := p.ext.SigOptions(addr)
+	if err != nil {
+		return nil, err
+	}
+	ext.Signature = types.ExtrinsicSignatureV4{
+		Signer:    types.NewMultiAddressFromAccountID(addr[:]),
+		Signature: types.MultiSignature{IsSr25519: true},
+		Era:       types.ExtrinsicEra{IsImmortalEra: true},
+		Nonce:     opts.Nonce,
+		Tip:       opts.Tip,
+	}
+	ext.Version |= types.ExtrinsicBitSigned
+	return p.api.EstimateFee(ext)
+}
+
+// CheckFunds checks that `addr` can pay `amount` plus the fee of the call
+// and stays above the existential deposit. Returns an InsufficientFundsError
+// otherwise.
+func (p *Pallet) CheckFunds(call *ExtName, args []interface{}, addr types.AccountID, amount *big.Int) error {
+	return p.CheckBatchFunds(call, args, addr, amount, 1)
+}
+
+// CheckBatchFunds checks that `addr` can pay `amount` plus the fees of `n`
+// calls that are as expensive as the given one and stays above the
+// existential deposit. Returns an InsufficientFundsError otherwise.
+func (p *Pallet) CheckBatchFunds(call *ExtName, args []interface{}, addr types.AccountID, amount *big.Int, n int) error {
+	fee, err := p.EstimateFee(call, args, addr)
+	if err != nil {
+		return err
+	}
+	fee.Mul(fee, big.NewInt(int64(n)))
+	return p.api.CheckFunds(addr, amount, fee)
+}
+
+// DryRun executes a signed Extrinsic on top of the latest block without
+// submitting it. See API.DryRun.
+func (p *Pallet) DryRun(ext *types.Extrinsic) (*types.DispatchError, error) {
+	return p.api.DryRun(ext)
+}
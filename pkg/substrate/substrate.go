@@ -45,6 +45,28 @@ type (
 		BuildKey(prefix, method string, args ...[]byte) (gsrpc.StorageKey, error)
 	}
 
+	// BlockQueryer can additionally query blocks and the storage at specific
+	// blocks.
+	BlockQueryer interface {
+		StorageQueryer
+
+		// Block returns the block with the given hash.
+		Block(gsrpc.Hash) (*gsrpc.SignedBlock, error)
+		// Header returns the header of the block with the given hash.
+		Header(gsrpc.Hash) (*gsrpc.Header, error)
+		// QueryAt returns the value of a storage key at the end of the block
+		// with the given hash. The value is empty if the key is not set.
+		QueryAt(block gsrpc.Hash, key gsrpc.StorageKey) (gsrpc.StorageDataRaw, error)
+	}
+
+	// FinalityQueryer can additionally query the last finalized block.
+	FinalityQueryer interface {
+		BlockQueryer
+
+		// FinalizedHead returns the hash of the last finalized block.
+		FinalizedHead() (gsrpc.Hash, error)
+	}
+
 	// ChainReader is used to query the on-chain state.
 	ChainReader interface {
 		// Metadata returns the latest metadata.
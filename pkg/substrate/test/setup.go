@@ -52,7 +52,7 @@ const PastBlocks = 100
 //go:embed chain.json
 var chainCfgFile []byte
 
-func NewSetup(t *testing.T) *Setup {
+func NewSetup(t testing.TB) *Setup {
 	cfg := LoadChainCfg(t)
 	api, err := substrate.NewAPI(cfg.ChainUrl, cfg.NetworkID)
 	require.NoError(t, err)
@@ -60,7 +60,7 @@ func NewSetup(t *testing.T) *Setup {
 	return &Setup{cfg, api}
 }
 
-func LoadChainCfg(t *testing.T) ChainCfg {
+func LoadChainCfg(t testing.TB) ChainCfg {
 	var cfg ChainCfg
 	require.NoError(t, json.Unmarshal(chainCfgFile, &cfg))
 	cfg.BlockTime = time.Duration(cfg.BlockTimeMs) * time.Millisecond
@@ -37,6 +37,16 @@ type (
 		storage      StorageQueryer
 	}
 
+	// BlockTimeout can be used to wait until a specific block number is
+	// reached by the blockchain. Implements the Perun Timeout interface.
+	BlockTimeout struct {
+		log.Embedding
+
+		when         types.BlockNumber
+		pollInterval time.Duration
+		storage      StorageQueryer
+	}
+
 	// TimePoint as defined by pallet Timestamp.
 	TimePoint uint64
 )
@@ -98,13 +108,37 @@ func (t *Timeout) Wait(ctx context.Context) error {
 	}
 }
 
+// Remaining returns the time until the timeout elapses, measured in chain
+// time. Returns zero if it already elapsed.
+func (t *Timeout) Remaining(context.Context) (time.Duration, error) {
+	now, err := t.pollTime()
+	if err != nil {
+		return 0, err
+	}
+	if rem := t.when.Sub(now); rem > 0 {
+		return rem, nil
+	}
+	return 0, nil
+}
+
 // pollTime returns the current time of the blockchain.
 func (t *Timeout) pollTime() (time.Time, error) {
-	key, err := t.storage.BuildKey("Timestamp", "Now")
+	now, err := ChainTime(t.storage)
+	if err != nil {
+		return time.Unix(0, 0), err
+	}
+	t.Log().Tracef("Polled time: %v", now.UTC())
+	return now, nil
+}
+
+// ChainTime returns the time of the latest block as defined by pallet
+// Timestamp. The precision is one second.
+func ChainTime(storage StorageQueryer) (time.Time, error) {
+	key, err := storage.BuildKey("Timestamp", "Now")
 	if err != nil {
 		return time.Unix(0, 0), err
 	}
-	_now, err := t.storage.QueryOne(0, key)
+	_now, err := storage.QueryOne(0, key)
 	if err != nil {
 		return time.Unix(0, 0), err
 	}
@@ -113,7 +147,87 @@ func (t *Timeout) pollTime() (time.Time, error) {
 	if err := types.DecodeFromBytes(_now.StorageData, &now); err != nil {
 		return time.Unix(0, 0), err
 	}
-	unixNow := time.Unix(int64(now/1000), 0)
-	t.Log().Tracef("Polled time: %v", unixNow.UTC())
-	return unixNow, nil
+	return time.Unix(int64(now/1000), 0), nil
+}
+
+// NewBlockTimeout returns a new BlockTimeout which expires once the block
+// with number `when` was produced.
+func NewBlockTimeout(storage StorageQueryer, when types.BlockNumber, pollInterval time.Duration) *BlockTimeout {
+	return &BlockTimeout{log.MakeEmbedding(log.Default()), when, pollInterval, storage}
+}
+
+// IsElapsed returns whether the timeout is elapsed.
+func (t *BlockTimeout) IsElapsed(context.Context) bool {
+	// Get the current block number.
+	now, err := t.pollBlockNumber()
+	if err != nil {
+		log.WithError(err).Error("Polling block number failed")
+		return false
+	}
+	elapsed := now >= t.when
+	if elapsed {
+		t.Log().Tracef("Timeout elapsed since %d blocks", now-t.when)
+	} else {
+		t.Log().Tracef("Timeout target in %d blocks", t.when-now)
+	}
+
+	return elapsed
+}
+
+// Wait waits for the timeout or until the context is cancelled.
+func (t *BlockTimeout) Wait(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(t.pollInterval):
+			if t.IsElapsed(ctx) {
+				return nil
+			}
+		}
+	}
+}
+
+// RemainingBlocks returns the number of blocks until the timeout elapses.
+// Returns zero if it already elapsed.
+func (t *BlockTimeout) RemainingBlocks(context.Context) (types.BlockNumber, error) {
+	now, err := t.pollBlockNumber()
+	if err != nil {
+		return 0, err
+	}
+	if now >= t.when {
+		return 0, nil
+	}
+	return t.when - now, nil
+}
+
+// pollBlockNumber returns the number of the latest block.
+func (t *BlockTimeout) pollBlockNumber() (types.BlockNumber, error) {
+	now, err := ChainBlockNumber(t.storage)
+	if err != nil {
+		return 0, err
+	}
+	t.Log().Tracef("Polled block number: %d", now)
+	return now, nil
+}
+
+// ChainBlockNumber returns the number of the latest block as defined by
+// pallet System.
+func ChainBlockNumber(storage StorageQueryer) (types.BlockNumber, error) {
+	key, err := storage.BuildKey("System", "Number")
+	if err != nil {
+		return 0, err
+	}
+	_now, err := storage.QueryOne(0, key)
+	if err != nil {
+		return 0, err
+	}
+
+	// The storage contains the number as plain u32 and not compact encoded
+	// like types.BlockNumber.
+	var now uint32
+	if err := types.DecodeFromBytes(_now.StorageData, &now); err != nil {
+		return 0, err
+	}
+	return types.BlockNumber(now), nil
 }
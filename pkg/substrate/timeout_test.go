@@ -48,3 +48,26 @@ func TestTimeout(t *testing.T) {
 	require.NoError(t, err)
 	assert.True(t, timeout.IsElapsed(context.Background()))
 }
+
+func TestBlockTimeout(t *testing.T) {
+	s := test.NewSetup(t)
+
+	head, err := s.API.LastHeader()
+	require.NoError(t, err)
+	const waitBlocks = 10
+	waitTime := waitBlocks * s.BlockTime
+	timeout := substrate.NewBlockTimeout(s.API, head.Number+waitBlocks, substrate.DefaultTimeoutPollInterval)
+
+	assert.False(t, timeout.IsElapsed(context.Background()))
+	// The Wait goroutine can outlive the assertion, so it only logs errors.
+	ctxtest.AssertNotTerminates(t, waitTime/2, func() {
+		err := timeout.Wait(context.Background())
+		if err != nil {
+			log.WithError(err).Error("Waiting for the block timeout failed.")
+		}
+	})
+	assert.False(t, timeout.IsElapsed(context.Background()))
+	ctxtest.AssertTerminates(t, waitTime, func() { err = timeout.Wait(context.Background()) })
+	require.NoError(t, err)
+	assert.True(t, timeout.IsElapsed(context.Background()))
+}
@@ -60,7 +60,7 @@ func (s *Hex) UnmarshalJSON(data []byte) error {
 
 // LoadDevAccounts loads all dev accounts by assuming that the config file is
 // in the passed directory.
-func LoadDevAccounts(t *testing.T) []*DevAccount {
+func LoadDevAccounts(t testing.TB) []*DevAccount {
 	var setups []*DevAccount
 	err := json.Unmarshal(AccountsCfg, &setups)
 	require.NoError(t, err)